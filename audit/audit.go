@@ -0,0 +1,212 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	log "github.com/Sirupsen/logrus"
+	nsx "github.com/shyiko/kubensx/context"
+	"io/ioutil"
+	"k8s.io/client-go/util/homedir"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Path to the audit log (KUBENSX_AUDIT_LOG takes precedence over ~/.kube/kubensx/audit.log).
+var Path = func() string {
+	if path := os.Getenv("KUBENSX_AUDIT_LOG"); path != "" {
+		return path
+	}
+	return filepath.Join(homedir.HomeDir(), ".kube", "kubensx", "audit.log")
+}()
+
+type Record struct {
+	Time    time.Time `json:"time"`
+	From    nsx.FQNS  `json:"from"`
+	To      nsx.FQNS  `json:"to"`
+	Command string    `json:"command"`
+	TTY     string    `json:"tty,omitempty"`
+	Session string    `json:"session,omitempty"`
+}
+
+// Append adds r to the audit log, keeping only the keep most recent records (0 means all of them).
+func Append(r Record, keep int) error {
+	if err := os.MkdirAll(filepath.Dir(Path), 0700); err != nil {
+		return err
+	}
+	// Path itself cannot be locked as truncate replaces it
+	lock, err := os.OpenFile(Path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := lockFile(lock); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if keep > 0 {
+		return truncate(keep)
+	}
+	return nil
+}
+
+// truncate drops all but n most recent records. Caller must hold the lock (see Append).
+func truncate(n int) error {
+	data, err := ioutil.ReadFile(Path)
+	if err != nil {
+		return err
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) <= n {
+		return nil
+	}
+	log.Debugf(`Dropped %d record(s) from "%s"`, len(lines)-n, Path)
+	// rename makes sure concurrent readers never see partially written log
+	f, err := ioutil.TempFile(filepath.Dir(Path), filepath.Base(Path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(strings.Join(lines[len(lines)-n:], "")); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), Path)
+}
+
+// Read returns records (oldest first) created at or after since.
+func Read(since time.Time) ([]Record, error) {
+	f, err := os.Open(Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var r []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			log.Debugf(`Skipped malformed audit record "%s" (%s)`, scanner.Text(), err.Error())
+			continue
+		}
+		if !record.Time.Before(since) {
+			r = append(r, record)
+		}
+	}
+	return r, scanner.Err()
+}
+
+type context struct {
+	nsx.Context
+	from    nsx.FQNS
+	command string
+	keep    int
+}
+
+// CommandExternal is the Command of a Record describing a switch made outside of kubensx (see nsx.Context.Drift).
+const CommandExternal = "outside of kubensx"
+
+// CommandExpire is the Command of a Record describing a switch back once "kubensx use --for" expires.
+const CommandExpire = "expire"
+
+// Wrap returns ctx that appends a Record to the audit log each time Commit changes current context
+// (see Append for keep).
+func Wrap(ctx nsx.Context, command string, keep int) nsx.Context {
+	return &context{Context: ctx, from: current(ctx), command: command, keep: keep}
+}
+
+func (ctx *context) Commit() error {
+	last, _, drifted := ctx.Context.Drift()
+	if err := ctx.Context.Commit(); err != nil {
+		return err
+	}
+	if drifted && last != ctx.from {
+		ctx.append(last, ctx.from, CommandExternal)
+	}
+	to := current(ctx.Context)
+	if to == ctx.from {
+		return nil
+	}
+	ctx.append(ctx.from, to, ctx.command)
+	ctx.from = to
+	return nil
+}
+
+func (ctx *context) append(from nsx.FQNS, to nsx.FQNS, command string) {
+	r := Record{
+		Time:    time.Now(),
+		From:    from,
+		To:      to,
+		Command: command,
+		TTY:     tty(),
+		Session: session(),
+	}
+	if err := Append(r, ctx.keep); err != nil {
+		log.Warnf(`Failed to append to "%s" (%s)`, Path, err.Error())
+	}
+}
+
+func current(ctx nsx.Context) nsx.FQNS {
+	return nsx.FQNS{User: ctx.User(), Cluster: ctx.Cluster(), NS: ctx.Namespace()}
+}
+
+func tty() string {
+	// linux-only (elsewhere tty is left empty)
+	if path, err := os.Readlink("/proc/self/fd/0"); err == nil && strings.HasPrefix(path, "/dev/") &&
+		path != os.DevNull {
+		return path
+	}
+	return ""
+}
+
+func session() string {
+	if id := os.Getenv("KUBENSX_SESSION"); id != "" {
+		return id
+	}
+	return "ppid:" + strconv.Itoa(os.Getppid())
+}
+
+// Frecency scores key(record.To) based on how often and how recently it was switched to.
+func Frecency(records []Record, now time.Time, key func(nsx.FQNS) string) map[string]float64 {
+	m := make(map[string]float64)
+	for _, r := range records {
+		m[key(r.To)] += weight(now.Sub(r.Time))
+	}
+	return m
+}
+
+func weight(age time.Duration) float64 {
+	switch {
+	case age < 4*time.Hour:
+		return 100
+	case age < 24*time.Hour:
+		return 70
+	case age < 7*24*time.Hour:
+		return 50
+	case age < 30*24*time.Hour:
+		return 30
+	}
+	return 10
+}
@@ -0,0 +1,144 @@
+package audit
+
+import (
+	nsx "github.com/shyiko/kubensx/context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func withTempLog(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "kubensx-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := Path
+	Path = filepath.Join(dir, "audit.log")
+	return func() {
+		Path = path
+		os.RemoveAll(dir)
+	}
+}
+
+func record(at time.Time, ns string) Record {
+	return Record{
+		Time:    at,
+		From:    nsx.FQNS{User: "alice", Cluster: "minikube", NS: "default"},
+		To:      nsx.FQNS{User: "alice", Cluster: "minikube", NS: ns},
+		Command: "use",
+	}
+}
+
+func TestAppend(t *testing.T) {
+	defer withTempLog(t)()
+	if r, err := Read(time.Time{}); err != nil || r != nil {
+		t.Fatalf("expected no records, got %v (%v)", r, err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	expected := []Record{record(now.Add(-time.Hour), "a"), record(now, "b")}
+	for _, r := range expected {
+		if err := Append(r, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := Read(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Fatalf("expected %v, got %v", expected, r)
+	}
+	if r, err = Read(now); err != nil || !reflect.DeepEqual(r, expected[1:]) {
+		t.Fatalf("expected %v, got %v (%v)", expected[1:], r, err)
+	}
+}
+
+func TestFrecency(t *testing.T) {
+	now := time.Now()
+	for _, test := range []struct {
+		name     string
+		ago      []time.Duration
+		expected float64
+	}{
+		{"hour", []time.Duration{time.Hour}, 100},
+		{"day", []time.Duration{5 * time.Hour}, 70},
+		{"week", []time.Duration{2 * 24 * time.Hour}, 50},
+		{"month", []time.Duration{10 * 24 * time.Hour}, 30},
+		{"older", []time.Duration{90 * 24 * time.Hour}, 10},
+		{"sum", []time.Duration{time.Minute, 5 * time.Hour, 90 * 24 * time.Hour}, 180},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var records []Record
+			for _, ago := range test.ago {
+				records = append(records, record(now.Add(-ago), "a"), record(now.Add(-ago-time.Second), "b"))
+			}
+			m := Frecency(records, now, func(fqns nsx.FQNS) string { return fqns.NS })
+			if m["a"] != test.expected || m["b"] != test.expected || len(m) != 2 {
+				t.Fatalf("expected %v for both a & b, got %v", test.expected, m)
+			}
+		})
+	}
+}
+
+func TestAppendKeep(t *testing.T) {
+	defer withTempLog(t)()
+	now := time.Now().UTC().Truncate(time.Second)
+	var records []Record
+	for i, ns := range []string{"a", "b", "c", "d"} {
+		records = append(records, record(now.Add(time.Duration(i)*time.Second), ns))
+	}
+	for _, r := range records {
+		if err := Append(r, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := Read(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r, records[2:]) {
+		t.Fatalf("expected %v, got %v", records[2:], r)
+	}
+	files, err := ioutil.ReadDir(filepath.Dir(Path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range files {
+		if file.Mode().Perm() != 0600 {
+			t.Fatalf("expected %s to be 0600, got %v", file.Name(), file.Mode())
+		}
+		names = append(names, file.Name())
+	}
+	if expected := []string{"audit.log", "audit.log.lock"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected nothing but %v to be left behind, got %v", expected, names)
+	}
+}
+
+func TestAppendLock(t *testing.T) {
+	defer withTempLog(t)()
+	if err := os.MkdirAll(filepath.Dir(Path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := os.OpenFile(Path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lockFile(lock); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- Append(record(time.Now(), "a"), 1) }()
+	select {
+	case <-done:
+		t.Fatal("expected Append to wait for the lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+	lock.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package audit
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile blocks until exclusive lock on f is acquired. Lock is released once f is closed.
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
@@ -0,0 +1,206 @@
+// Package backup keeps rotating copies of the kubeconfig file(s) kubensx is about to overwrite.
+package backup
+
+import (
+	"bytes"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"k8s.io/client-go/util/homedir"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Dir backups are kept in (KUBENSX_BACKUP_DIR takes precedence over ~/.kube/kubensx/backups).
+var Dir = func() string {
+	if dir := os.Getenv("KUBENSX_BACKUP_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(homedir.HomeDir(), ".kube", "kubensx", "backups")
+}()
+
+// Keep is the number of backups to keep (KUBENSX_BACKUPS, 10 by default; 0 turns backups off).
+var Keep = func() int {
+	if value := os.Getenv("KUBENSX_BACKUPS"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err == nil && n >= 0 {
+			return n
+		}
+		log.Warnf(`Ignored KUBENSX_BACKUPS="%s" (expected non-negative integer)`, value)
+	}
+	return 10
+}()
+
+const idLayout = "20060102T150405.000000000"
+
+type Backup struct {
+	ID   string
+	Time time.Time
+	// Files maps path of the original file to the path of its copy.
+	Files map[string]string
+}
+
+// Paths returns (sorted) paths of the original files.
+func (b Backup) Paths() []string {
+	r := make([]string, 0, len(b.Files))
+	for path := range b.Files {
+		r = append(r, path)
+	}
+	sort.Strings(r)
+	return r
+}
+
+// Create copies existing files (the ones that do not exist are skipped) into a new backup and deletes the oldest
+// backups beyond Keep.
+// No backup is created (ok is false) if Keep is 0, none of the files exist or files haven't changed since the
+// latest backup.
+func Create(paths []string) (b Backup, ok bool, err error) {
+	if Keep == 0 {
+		return Backup{}, false, nil
+	}
+	content := make(map[string][]byte)
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return Backup{}, false, err
+		}
+		data, err := ioutil.ReadFile(abs)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return Backup{}, false, err
+		}
+		content[abs] = data
+	}
+	if len(content) == 0 {
+		return Backup{}, false, nil
+	}
+	backups, err := List()
+	if err != nil {
+		return Backup{}, false, err
+	}
+	if len(backups) != 0 && unchanged(backups[0], content) {
+		log.Debugf(`Skipped backup (nothing has changed since "%s")`, backups[0].ID)
+		return Backup{}, false, nil
+	}
+	now := time.Now().UTC()
+	b = Backup{ID: now.Format(idLayout), Time: now, Files: make(map[string]string)}
+	dir := filepath.Join(Dir, b.ID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return Backup{}, false, err
+	}
+	for path, data := range content {
+		b.Files[path] = filepath.Join(dir, url.QueryEscape(path))
+		if err := ioutil.WriteFile(b.Files[path], data, 0600); err != nil {
+			return Backup{}, false, err
+		}
+	}
+	log.Debugf(`Backed up %v to "%s"`, b.Paths(), dir)
+	backups = append([]Backup{b}, backups...)
+	for _, stale := range backups[min(Keep, len(backups)):] {
+		log.Debugf(`Deleted backup "%s"`, stale.ID)
+		if err := os.RemoveAll(filepath.Join(Dir, stale.ID)); err != nil {
+			return b, true, err
+		}
+	}
+	return b, true, nil
+}
+
+func unchanged(b Backup, content map[string][]byte) bool {
+	if len(b.Files) != len(content) {
+		return false
+	}
+	for path, data := range content {
+		dup, ok := b.Files[path]
+		if !ok {
+			return false
+		}
+		prev, err := ioutil.ReadFile(dup)
+		if err != nil || !bytes.Equal(prev, data) {
+			return false
+		}
+	}
+	return true
+}
+
+// List returns backups (newest first).
+func List() ([]Backup, error) {
+	dirs, err := ioutil.ReadDir(Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var r []Backup
+	for _, dir := range dirs {
+		t, err := time.Parse(idLayout, dir.Name())
+		if err != nil || !dir.IsDir() {
+			log.Debugf(`Skipped "%s" (not a backup)`, filepath.Join(Dir, dir.Name()))
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(Dir, dir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		b := Backup{ID: dir.Name(), Time: t, Files: make(map[string]string)}
+		for _, file := range files {
+			path, err := url.QueryUnescape(file.Name())
+			if err != nil {
+				log.Debugf(`Skipped "%s" (not a backup)`, filepath.Join(Dir, dir.Name(), file.Name()))
+				continue
+			}
+			b.Files[path] = filepath.Join(Dir, dir.Name(), file.Name())
+		}
+		r = append(r, b)
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].ID > r[j].ID })
+	return r, nil
+}
+
+// Find returns backup by id (ok is false if there is no such backup).
+func Find(id string) (b Backup, ok bool, err error) {
+	backups, err := List()
+	if err != nil {
+		return Backup{}, false, err
+	}
+	for _, b := range backups {
+		if b.ID == id {
+			return b, true, nil
+		}
+	}
+	return Backup{}, false, nil
+}
+
+// Restore overwrites original files with their copies from b.
+func Restore(b Backup) error {
+	for _, path := range b.Paths() {
+		data, err := ioutil.ReadFile(b.Files[path])
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(0600)
+		if fi, err := os.Stat(path); err == nil {
+			mode = fi.Mode()
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, data, mode); err != nil {
+			return err
+		}
+		log.Debugf(`Restored "%s" from "%s"`, path, b.Files[path])
+	}
+	return nil
+}
+
+func min(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
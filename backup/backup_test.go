@@ -0,0 +1,68 @@
+package backup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCreate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubensx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(dir string, keep int) { Dir, Keep = dir, keep }(Dir, Keep)
+	Dir, Keep = filepath.Join(dir, "backups"), 2
+	config := filepath.Join(dir, "config")
+	write := func(content string) {
+		if err := ioutil.WriteFile(config, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	create := func() bool {
+		_, ok, err := Create([]string{config, filepath.Join(dir, "missing")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	if create() {
+		t.Fatal("expected no backup to be created when none of the files exist")
+	}
+	write("a")
+	if !create() || create() {
+		t.Fatal("expected backup to be created only if files have changed since the latest backup")
+	}
+	for _, content := range []string{"b", "c"} {
+		write(content)
+		create()
+	}
+	backups, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("expected %d backups to be kept, got %d", Keep, len(backups))
+	}
+	if !reflect.DeepEqual(backups[0].Paths(), []string{config}) {
+		t.Fatalf("expected %v, got %v", []string{config}, backups[0].Paths())
+	}
+	write("d")
+	if err := Restore(backups[1]); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(config); string(data) != "b" {
+		t.Fatalf(`expected "b" (the oldest backup kept), got "%s"`, data)
+	}
+	if b, ok, err := Find(backups[0].ID); err != nil || !ok || b.ID != backups[0].ID {
+		t.Fatalf("expected to find %s, got %v (%v)", backups[0].ID, b, err)
+	}
+	Keep = 0
+	write("e")
+	if create() {
+		t.Fatal("expected Keep=0 to turn backups off")
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"flag"
 	"fmt"
 	"github.com/posener/complete"
+	"github.com/shyiko/kubensx/backup"
 	nsx "github.com/shyiko/kubensx/context"
+	"github.com/shyiko/kubensx/settings"
 	"io"
 	"os"
 	"path/filepath"
@@ -33,6 +35,15 @@ func (c *Completion) GenZshCompletion(w io.Writer) error {
 	return nil
 }
 
+func (c *Completion) GenFishCompletion(w io.Writer) error {
+	bin, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "complete -c %s -f -a '(env COMP_LINE=(commandline -cp) %s)'\n", filepath.Base(bin), bin)
+	return nil
+}
+
 // complete.PredictSet(...) alternative
 /*
 type oneOf []string
@@ -56,27 +67,75 @@ func (c *Completion) Execute() (bool, error) {
 		Sub: complete.Commands{
 			"assoc": complete.Command{
 				Flags: complete.Flags{
-					"--delete":     complete.PredictNothing,
-					"-d":           complete.PredictNothing,
-					"--delete-all": complete.PredictNothing,
-					"--dry-run":    complete.PredictNothing,
-					"-x":           complete.PredictNothing,
-					"--exact":      complete.PredictNothing,
-					"-e":           complete.PredictNothing,
-					"--fuzzy":      complete.PredictNothing,
-					"-z":           complete.PredictNothing,
-					"--list":       complete.PredictNothing,
-					"-l":           complete.PredictNothing,
+					"--case-sensitive": complete.PredictNothing,
+					"--delete":         complete.PredictNothing,
+					"-d":               complete.PredictNothing,
+					"--delete-all":     complete.PredictNothing,
+					"--diff":           complete.PredictNothing,
+					"--dry-run":        complete.PredictNothing,
+					"-x":               complete.PredictNothing,
+					"--exact":          complete.PredictNothing,
+					"-e":               complete.PredictNothing,
+					"--fuzzy":          complete.PredictNothing,
+					"-z":               complete.PredictNothing,
+					"--regex":          complete.PredictNothing,
+					"-r":               complete.PredictNothing,
+					"--list":           complete.PredictNothing,
+					"-l":               complete.PredictNothing,
 				},
 				// todo:
 				// Args: oneOf(c.ctx().Users()),
 			},
+			"backups": complete.Command{
+				Sub: complete.Commands{
+					"ls": complete.Command{},
+				},
+			},
 			"completion": complete.Command{
 				Sub: complete.Commands{
 					"bash": complete.Command{},
+					"fish": complete.Command{},
 					"zsh":  complete.Command{},
 				},
 			},
+			"config": complete.Command{
+				Sub: complete.Commands{
+					"export": complete.Command{
+						Flags: complete.Flags{
+							"--me":     complete.PredictAnything,
+							"--output": complete.PredictSet("yaml", "json"),
+							"-o":       complete.PredictSet("yaml", "json"),
+						},
+					},
+					"import": complete.Command{
+						Flags: complete.Flags{
+							"--dry-run": complete.PredictNothing,
+							"-x":        complete.PredictNothing,
+							"--me":      complete.PredictAnything,
+							"--merge":   complete.PredictNothing,
+							"--replace": complete.PredictNothing,
+						},
+						Args: complete.PredictFiles("*"),
+					},
+					"set": complete.Command{
+						Flags: complete.Flags{
+							"--delete": complete.PredictNothing,
+							"-d":       complete.PredictNothing,
+						},
+						Args: complete.PredictFunc(func(args complete.Args) []string {
+							if len(args.Completed) != 0 {
+								return nil
+							}
+							r := make([]string, len(settings.All))
+							for i, s := range settings.All {
+								r[i] = s.Key
+							}
+							return r
+						}),
+					},
+					"view": complete.Command{},
+				},
+			},
 			"current": complete.Command{
 				Flags: complete.Flags{
 					"--cluster":   complete.PredictNothing,
@@ -84,56 +143,189 @@ func (c *Completion) Execute() (bool, error) {
 					"--namespace": complete.PredictNothing,
 					"--ns":        complete.PredictNothing,
 					"-n":          complete.PredictNothing,
+					"--source":    complete.PredictNothing,
+					"--template":  complete.PredictAnything,
 					"--user":      complete.PredictNothing,
 					"-u":          complete.PredictNothing,
+					"--verbose":   complete.PredictNothing,
+					"-v":          complete.PredictNothing,
+				},
+			},
+			"gc": complete.Command{
+				Flags: complete.Flags{
+					"--diff": complete.PredictNothing,
 				},
 			},
+			"hook": complete.Command{
+				Flags: complete.Flags{
+					"--env": complete.PredictNothing,
+				},
+				Args: complete.PredictSet("bash", "zsh", "fish"),
+			},
+			"init": complete.Command{
+				Flags: complete.Flags{
+					"--env":    complete.PredictNothing,
+					"--key":    complete.PredictAnything,
+					"--prompt": complete.PredictNothing,
+				},
+				Args: complete.PredictSet("bash", "zsh", "fish"),
+			},
 			"ls": complete.Command{
 				Flags: complete.Flags{
 					"--users":      complete.PredictNothing,
 					"-u":           complete.PredictNothing,
 					"--clusters":   complete.PredictNothing,
 					"-c":           complete.PredictNothing,
+					"--contexts":   complete.PredictNothing,
 					"--namespaces": complete.PredictNothing,
 					"-n":           complete.PredictNothing,
+					"--tag":        complete.PredictAnything,
+				},
+			},
+			"log": complete.Command{
+				Flags: complete.Flags{
+					"--output": complete.PredictSet("json"),
+					"-o":       complete.PredictSet("json"),
+					"--since":  complete.PredictAnything,
+				},
+			},
+			"materialize": complete.Command{
+				Flags: complete.Flags{
+					"--assoc":     complete.PredictNothing,
+					"--delete":    complete.PredictNothing,
+					"-d":          complete.PredictNothing,
+					"--dry-run":   complete.PredictNothing,
+					"-x":          complete.PredictNothing,
+					"--overwrite": complete.PredictNothing,
+					"--template":  complete.PredictAnything,
 				},
 			},
 			"ns-list": complete.Command{
 				Flags: complete.Flags{
-					"--delete":     complete.PredictNothing,
-					"-d":           complete.PredictNothing,
-					"--delete-all": complete.PredictNothing,
-					"--dry-run":    complete.PredictNothing,
-					"-x":           complete.PredictNothing,
-					"--exact":      complete.PredictNothing,
-					"-e":           complete.PredictNothing,
-					"--fuzzy":      complete.PredictNothing,
-					"-z":           complete.PredictNothing,
-					"--list":       complete.PredictNothing,
-					"-l":           complete.PredictNothing,
+					"--case-sensitive": complete.PredictNothing,
+					"--delete":         complete.PredictNothing,
+					"-d":               complete.PredictNothing,
+					"--delete-all":     complete.PredictNothing,
+					"--diff":           complete.PredictNothing,
+					"--dry-run":        complete.PredictNothing,
+					"-x":               complete.PredictNothing,
+					"--exact":          complete.PredictNothing,
+					"-e":               complete.PredictNothing,
+					"--fuzzy":          complete.PredictNothing,
+					"-z":               complete.PredictNothing,
+					"--regex":          complete.PredictNothing,
+					"-r":               complete.PredictNothing,
+					"--list":           complete.PredictNothing,
+					"-l":               complete.PredictNothing,
 				},
 				// todo:
 				// Args: oneOf(c.ctx().Users()),
 			},
+			"protect": complete.Command{
+				Flags: complete.Flags{
+					"--case-sensitive": complete.PredictNothing,
+					"--delete":         complete.PredictNothing,
+					"-d":               complete.PredictNothing,
+					"--delete-all":     complete.PredictNothing,
+					"--dry-run":        complete.PredictNothing,
+					"-x":               complete.PredictNothing,
+					"--exact":          complete.PredictNothing,
+					"-e":               complete.PredictNothing,
+					"--fuzzy":          complete.PredictNothing,
+					"-z":               complete.PredictNothing,
+					"--regex":          complete.PredictNothing,
+					"-r":               complete.PredictNothing,
+					"--list":           complete.PredictNothing,
+					"-l":               complete.PredictNothing,
+				},
+			},
+			"save": complete.Command{
+				Flags: complete.Flags{
+					"--case-sensitive": complete.PredictNothing,
+					"--delete":         complete.PredictNothing,
+					"-d":               complete.PredictNothing,
+					"--delete-all":     complete.PredictNothing,
+					"--dry-run":        complete.PredictNothing,
+					"-x":               complete.PredictNothing,
+					"--exact":          complete.PredictNothing,
+					"-e":               complete.PredictNothing,
+					"--force":          complete.PredictNothing,
+					"-f":               complete.PredictNothing,
+					"--fuzzy":          complete.PredictNothing,
+					"-z":               complete.PredictNothing,
+					"--ignore-assoc":   complete.PredictNothing,
+					"--ignore-ns-list": complete.PredictNothing,
+					"--list":           complete.PredictNothing,
+					"-l":               complete.PredictNothing,
+					"--overwrite":      complete.PredictNothing,
+					"--regex":          complete.PredictNothing,
+					"-r":               complete.PredictNothing,
+				},
+			},
+			"tag": complete.Command{
+				Flags: complete.Flags{
+					"--case-sensitive": complete.PredictNothing,
+					"--delete":         complete.PredictNothing,
+					"-d":               complete.PredictNothing,
+					"--delete-all":     complete.PredictNothing,
+					"--dry-run":        complete.PredictNothing,
+					"-x":               complete.PredictNothing,
+					"--exact":          complete.PredictNothing,
+					"-e":               complete.PredictNothing,
+					"--fuzzy":          complete.PredictNothing,
+					"-z":               complete.PredictNothing,
+					"--regex":          complete.PredictNothing,
+					"-r":               complete.PredictNothing,
+					"--list":           complete.PredictNothing,
+					"-l":               complete.PredictNothing,
+				},
+			},
+			"undo": complete.Command{
+				Flags: complete.Flags{
+					"--dry-run": complete.PredictNothing,
+					"-x":        complete.PredictNothing,
+					"--yes":     complete.PredictNothing,
+					"-y":        complete.PredictNothing,
+				},
+				Args: complete.PredictFunc(func(args complete.Args) []string {
+					backups, _ := backup.List()
+					r := make([]string, len(backups))
+					for i, b := range backups {
+						r[i] = b.ID
+					}
+					return r
+				}),
+			},
 			"use": complete.Command{
 				Flags: complete.Flags{
+					"--case-sensitive": complete.PredictNothing,
 					"--cluster":        complete.PredictNothing,
 					"-c":               complete.PredictNothing,
+					"--context":        complete.PredictNothing,
+					"--diff":           complete.PredictNothing,
 					"--dry-run":        complete.PredictNothing,
 					"-x":               complete.PredictNothing,
 					"--exact":          complete.PredictNothing,
 					"-e":               complete.PredictNothing,
+					"--for":            complete.PredictAnything,
 					"--force":          complete.PredictNothing,
 					"-f":               complete.PredictNothing,
+					"--from-dir":       complete.PredictNothing,
 					"--fuzzy":          complete.PredictNothing,
 					"-z":               complete.PredictNothing,
+					"--regex":          complete.PredictNothing,
+					"-r":               complete.PredictNothing,
 					"--ignore-assoc":   complete.PredictNothing,
 					"--ignore-ns-list": complete.PredictNothing,
+					"--in-place":       complete.PredictNothing,
 					"--namespace":      complete.PredictNothing,
+					"--no-auto-select": complete.PredictNothing,
 					"--ns":             complete.PredictNothing,
 					"-n":               complete.PredictNothing,
 					"--user":           complete.PredictNothing,
 					"-u":               complete.PredictNothing,
+					"--yes":            complete.PredictNothing,
+					"-y":               complete.PredictNothing,
 				},
 				// todo:
 				// Args: oneOf(c.ctx().Users()),
@@ -141,16 +333,39 @@ func (c *Completion) Execute() (bool, error) {
 			"help": complete.Command{
 				Sub: complete.Commands{
 					"assoc": complete.Command{},
+					"backups": complete.Command{
+						Sub: complete.Commands{
+							"ls": complete.Command{},
+						},
+					},
 					"completion": complete.Command{
 						Sub: complete.Commands{
 							"bash": complete.Command{},
+							"fish": complete.Command{},
 							"zsh":  complete.Command{},
 						},
 					},
-					"current": complete.Command{},
-					"ls":      complete.Command{},
-					"ns-list": complete.Command{},
-					"use":     complete.Command{},
+					"config": complete.Command{
+						Sub: complete.Commands{
+							"export": complete.Command{},
+							"import": complete.Command{},
+							"set":    complete.Command{},
+							"view":   complete.Command{},
+						},
+					},
+					"current":     complete.Command{},
+					"gc":          complete.Command{},
+					"hook":        complete.Command{},
+					"init":        complete.Command{},
+					"log":         complete.Command{},
+					"ls":          complete.Command{},
+					"materialize": complete.Command{},
+					"ns-list":     complete.Command{},
+					"protect":     complete.Command{},
+					"save":        complete.Command{},
+					"tag":         complete.Command{},
+					"undo":        complete.Command{},
+					"use":         complete.Command{},
 				},
 			},
 		},
@@ -161,6 +376,7 @@ func (c *Completion) Execute() (bool, error) {
 			"--debug":      complete.PredictNothing,
 			"--kubeconfig": complete.PredictFiles("*"),
 			"--no-color":   complete.PredictNothing,
+			"--no-input":   complete.PredictNothing,
 			"--help":       complete.PredictNothing,
 			"-h":           complete.PredictNothing,
 		},
@@ -169,6 +385,7 @@ func (c *Completion) Execute() (bool, error) {
 	run.Sub["c"] = run.Sub["current"]
 	run.Sub["l"] = run.Sub["ls"]
 	run.Sub["n"] = run.Sub["ns-list"]
+	run.Sub["t"] = run.Sub["tag"]
 	run.Sub["u"] = run.Sub["use"]
 	completion := complete.New(filepath.Base(bin), run)
 	if os.Getenv("COMP_LINE") != "" {
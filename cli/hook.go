@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/shyiko/kubensx/selector"
+	"io"
+	"os"
+)
+
+// Hook scripts call "kubensx hook --env <shell>" whenever working directory changes and evaluate its output.
+// The output puts shell into the overlay pinned by .kubensx, or takes it out (see "kubensx hook --help").
+// kubensx_ps1 is refreshed afterwards if defined (see Gen*Init).
+// The overlay created by the hook is deleted when shell exits.
+
+func GenBashHook(w io.Writer) error {
+	bin, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, `_kubensx_hook() {
+  if [ "$PWD" != "$_KUBENSX_PWD" ]; then
+    _KUBENSX_PWD="$PWD"
+    eval "$(%s hook --env bash)"
+    if declare -F _kubensx_refresh >/dev/null; then _kubensx_refresh; fi
+  fi
+}
+if [[ ";${PROMPT_COMMAND:-};" != *";_kubensx_hook;"* ]]; then
+  PROMPT_COMMAND="_kubensx_hook${PROMPT_COMMAND:+;$PROMPT_COMMAND}"
+fi
+_kubensx_hook_exit() {
+  if [ -n "$KUBENSX_PIN" ] && [ -n "$KUBENSX_OVERLAY" ] && [ "$KUBENSX_OVERLAY" != "$KUBENSX_SESSION" ]; then
+    rm -f "$KUBENSX_OVERLAY"
+  fi
+}
+_kubensx_exit="$(trap -p EXIT)"
+if [[ "$_kubensx_exit" != *_kubensx_hook_exit* ]]; then
+  _kubensx_exit="${_kubensx_exit#trap -- }"
+  _kubensx_exit="${_kubensx_exit%% EXIT}"
+  trap "_kubensx_hook_exit${_kubensx_exit:+; eval $_kubensx_exit}" EXIT
+fi
+unset _kubensx_exit
+`, selector.Quote(bin))
+	return nil
+}
+
+func GenZshHook(w io.Writer) error {
+	bin, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, `_kubensx_hook() {
+  eval "$(%s hook --env zsh)"
+  if (( $+functions[_kubensx_refresh] )); then _kubensx_refresh; fi
+}
+typeset -ag chpwd_functions
+if [[ -z "${chpwd_functions[(r)_kubensx_hook]+1}" ]]; then
+  chpwd_functions=(_kubensx_hook $chpwd_functions)
+fi
+_kubensx_hook_exit() {
+  if [[ -n "$KUBENSX_PIN" && -n "$KUBENSX_OVERLAY" && "$KUBENSX_OVERLAY" != "$KUBENSX_SESSION" ]]; then
+    rm -f "$KUBENSX_OVERLAY"
+  fi
+}
+typeset -ag zshexit_functions
+if [[ -z "${zshexit_functions[(r)_kubensx_hook_exit]+1}" ]]; then
+  zshexit_functions+=(_kubensx_hook_exit)
+fi
+_kubensx_hook
+`, selector.Quote(bin))
+	return nil
+}
+
+func GenFishHook(w io.Writer) error {
+	bin, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, `function _kubensx_hook --on-variable PWD
+  %s hook --env fish | source
+  if functions -q _kubensx_refresh
+    _kubensx_refresh
+  end
+end
+function _kubensx_hook_exit --on-event fish_exit
+  if test -n "$KUBENSX_PIN" -a -n "$KUBENSX_OVERLAY" -a "$KUBENSX_OVERLAY" != "$KUBENSX_SESSION"
+    rm -f $KUBENSX_OVERLAY
+  end
+end
+_kubensx_hook
+`, selector.Quote(bin))
+	return nil
+}
@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/shyiko/kubensx/selector"
+	"io"
+	"os"
+)
+
+// InitOptions control what Gen*Init scripts include on top of completion, session & kubensx wrapper.
+type InitOptions struct {
+	// Prompt prepends kubensx_ps1 to the prompt.
+	Prompt bool
+	// Key is the key sequence that opens the picker, e.g. \ek for Alt+k. "" means none.
+	Key string
+}
+
+// Init scripts register completion and put shell into a session, so that "kubensx use" affects current shell only.
+// The session overlay is deleted on exit. kubensx is wrapped into a function that keeps kubensx_ps1 up to date.
+
+func (c *Completion) GenBashInit(w io.Writer, opts InitOptions) error {
+	if err := c.GenBashCompletion(w); err != nil {
+		return err
+	}
+	bin, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	// existing EXIT trap (if any) is kept
+	fmt.Fprintf(w, `eval "$(%[1]s init --env bash)"
+_kubensx_exit="$(trap -p EXIT)"
+_kubensx_exit="${_kubensx_exit#trap -- }"
+_kubensx_exit="${_kubensx_exit%% EXIT}"
+trap "rm -f \"\$KUBENSX_SESSION\"${_kubensx_exit:+; eval $_kubensx_exit}" EXIT
+unset _kubensx_exit
+kubensx() {
+  %[1]s "$@"
+  local code=$?
+  _kubensx_refresh
+  return $code
+}
+_kubensx_refresh() {
+  _KUBENSX_PS1="$(%[1]s current -cn 2>/dev/null)"
+}
+kubensx_ps1() {
+  if [ -n "$_KUBENSX_PS1" ]; then printf '(%%s) ' "$_KUBENSX_PS1"; fi
+}
+_kubensx_refresh
+`, selector.Quote(bin))
+	if opts.Prompt {
+		fmt.Fprint(w, `case "$PS1" in *kubensx_ps1*) ;; *) PS1='$(kubensx_ps1)'"$PS1" ;; esac
+`)
+	}
+	if opts.Key != "" {
+		fmt.Fprintf(w, `bind -x '"%s": kubensx use'
+`, opts.Key)
+	}
+	return nil
+}
+
+func (c *Completion) GenZshInit(w io.Writer, opts InitOptions) error {
+	if err := c.GenZshCompletion(w); err != nil {
+		return err
+	}
+	bin, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, `eval "$(%[1]s init --env zsh)"
+_kubensx_cleanup() {
+  rm -f "$KUBENSX_SESSION"
+}
+zshexit_functions+=(_kubensx_cleanup)
+kubensx() {
+  %[1]s "$@"
+  local code=$?
+  _kubensx_refresh
+  return $code
+}
+_kubensx_refresh() {
+  _KUBENSX_PS1="$(%[1]s current -cn 2>/dev/null)"
+}
+kubensx_ps1() {
+  if [[ -n "$_KUBENSX_PS1" ]]; then printf '(%%s) ' "$_KUBENSX_PS1"; fi
+}
+_kubensx_refresh
+`, selector.Quote(bin))
+	if opts.Prompt {
+		fmt.Fprint(w, `setopt prompt_subst
+if [[ "$PROMPT" != *kubensx_ps1* ]]; then PROMPT='$(kubensx_ps1)'"$PROMPT"; fi
+`)
+	}
+	if opts.Key != "" {
+		fmt.Fprintf(w, `_kubensx_use() {
+  zle -I
+  kubensx use </dev/tty
+  zle reset-prompt
+}
+zle -N _kubensx_use
+bindkey '%s' _kubensx_use
+`, opts.Key)
+	}
+	return nil
+}
+
+func (c *Completion) GenFishInit(w io.Writer, opts InitOptions) error {
+	if err := c.GenFishCompletion(w); err != nil {
+		return err
+	}
+	bin, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, `%[1]s init --env fish | source
+function _kubensx_cleanup --on-event fish_exit
+  rm -f $KUBENSX_SESSION
+end
+function kubensx
+  %[1]s $argv
+  set -l code $status
+  _kubensx_refresh
+  return $code
+end
+function _kubensx_refresh
+  set -g _KUBENSX_PS1 (%[1]s current -cn 2>/dev/null)
+end
+function kubensx_ps1
+  if test -n "$_KUBENSX_PS1"
+    printf '(%%s) ' $_KUBENSX_PS1
+  end
+end
+_kubensx_refresh
+`, selector.Quote(bin))
+	if opts.Prompt {
+		fmt.Fprint(w, `if not functions -q _kubensx_fish_prompt
+  functions -c fish_prompt _kubensx_fish_prompt
+  function fish_prompt
+    kubensx_ps1
+    _kubensx_fish_prompt
+  end
+end
+`)
+	}
+	if opts.Key != "" {
+		fmt.Fprintf(w, `bind %s 'kubensx use; commandline -f repaint'
+`, opts.Key)
+	}
+	return nil
+}
@@ -1,5 +1,7 @@
 package context
 
+import "time"
+
 type Context interface {
 	SetUser(value string)
 	User() string
@@ -14,6 +16,15 @@ type Context interface {
 	NamespacePrevious() string
 	Namespaces() ([]string, error)
 	NamespaceView() ([]string, error)
+	// Contexts returns named contexts (e.g. created by gcloud, aws eks, az aks) as name -> user:cluster/namespace
+	// (contexts kubensx uses for its own purposes are not included).
+	Contexts() map[string]FQNS
+	// Saved returns named contexts created or taken over by Save.
+	Saved() map[string]FQNS
+	// Save creates or updates named context. false means there was nothing to change.
+	Save(name string, fqns FQNS) bool
+	// Unsave deletes named context created by Save. Other contexts are left intact and false is returned.
+	Unsave(name string) bool
 
 	Associate(user string, cluster string) bool
 	UsersByCluster() map[string][]string // cluster -> []user
@@ -24,11 +35,52 @@ type Context interface {
 	SetExplicitNamespace(user string, cluster string, namespace string) bool
 	DeleteExplicitNamespace(user string, cluster string, namespace string) bool
 
+	Tags() map[string][]string // cluster -> []tag
+	Tag(cluster string, tag string) bool
+	Untag(cluster string, tag string) bool
+
+	Protected() map[string][]string // cluster -> []namespace ("" stands for the entire cluster)
+	Protect(cluster string, namespace string) bool
+	Unprotect(cluster string, namespace string) bool
+
+	// Expiry returns context to revert to once deadline is reached (ok is false unless SetExpiry was called).
+	Expiry() (revertTo FQNS, deadline time.Time, ok bool)
+	SetExpiry(revertTo FQNS, deadline time.Time)
+	DeleteExpiry() bool
+
+	// CurrentContext returns the name of the context current-context points to
+	// (kubensx-current unless one of the named contexts is in use).
+	CurrentContext() string
+	// Drift returns user:cluster/namespace (and the name of the context) kubensx left current as of the last Commit
+	// if current-context has been changed outside of kubensx since (e.g. with kubectl config use-context/set-context)
+	// (ok is false otherwise).
+	Drift() (last FQNS, lastContext string, ok bool)
+
+	// Begin starts a (nested) transaction.
+	Begin()
+	// Rollback discards changes made since the matching Begin (or since the last Commit if there is none).
+	Rollback()
+	// Diff returns serialized config as of the last Commit and the one Commit is going to write.
+	Diff() (before string, after string, err error)
 	Commit() error
 }
 
+// DryRun returns ctx that discards changes on Commit (instead of writing them).
+func DryRun(ctx Context) Context {
+	return dryRun{ctx}
+}
+
+type dryRun struct {
+	Context
+}
+
+func (ctx dryRun) Commit() error {
+	ctx.Rollback()
+	return nil
+}
+
 type FQNS struct {
-	User    string
-	Cluster string
-	NS      string
+	User    string `json:"user"`
+	Cluster string `json:"cluster"`
+	NS      string `json:"namespace"`
 }
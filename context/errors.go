@@ -0,0 +1,48 @@
+package context
+
+import "fmt"
+
+// Code tells what kind of failure an Error is.
+// kubensx uses it as an exit code (see "Exit codes" in README.md), so the values must not change.
+type Code int
+
+const (
+	CodeUnknown Code = 1
+	// CodeUsage means invalid flag(s)/argument(s) (e.g. malformed pattern).
+	CodeUsage Code = 2
+	// CodeInputRequired means user had to be prompted (e.g. pattern is ambiguous) but prompting was not allowed.
+	CodeInputRequired Code = 4
+	// CodeNoMatch means pattern did not match anything.
+	CodeNoMatch Code = 5
+	// CodeUnreachable means cluster could not be reached.
+	CodeUnreachable Code = 6
+	// CodeForbidden means user is not authorized to perform the request (e.g. to list namespaces).
+	CodeForbidden Code = 7
+	// CodeAborted means operation was cancelled by user (e.g. Ctrl-C, confirmation mismatch).
+	CodeAborted Code = 8
+)
+
+type Error struct {
+	Code Code
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func NewError(code Code, err error) error {
+	return &Error{Code: code, Err: err}
+}
+
+func Errorf(code Code, format string, a ...interface{}) error {
+	return &Error{Code: code, Err: fmt.Errorf(format, a...)}
+}
+
+// CodeOf returns the Code of err (CodeUnknown unless err is *Error).
+func CodeOf(err error) Code {
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+	return CodeUnknown
+}
@@ -1,7 +1,9 @@
 package kubectl
 
 import (
+	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"github.com/shyiko/kubensx/backup"
 	nsx "github.com/shyiko/kubensx/context"
 	"k8s.io/apimachinery/pkg/api/errors"
 	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -9,25 +11,60 @@ import (
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	k8sclientcmd "k8s.io/client-go/tools/clientcmd"
 	k8sclientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"net/url"
+	"os"
+	"reflect"
 	"sort"
 	"strings"
+	"time"
 )
 
 const (
-	assocPrefix    = "kubensx-assoc:"
-	assocSeparator = ":"
-	nsPrefix       = "kubensx-ns:"
-	nsSeparator    = "/"
-	contextCurrent = "kubensx-current"
-	contextPrev    = "kubensx-prev"
+	// reservedPrefix is shared by all the contexts kubensx uses for its own purposes (e.g. kubensx-current)
+	reservedPrefix   = "kubensx-"
+	assocPrefix      = "kubensx-assoc:"
+	assocSeparator   = ":"
+	nsPrefix         = "kubensx-ns:"
+	nsSeparator      = "/"
+	tagPrefix        = "kubensx-tag:"
+	tagSeparator     = "/"
+	protectPrefix    = "kubensx-protected:"
+	protectSeparator = "/"
+	expiryPrefix     = "kubensx-expiry:"
+	savedPrefix      = "kubensx-saved:"
+	lastPrefix       = "kubensx-last:"
+	contextCurrent   = "kubensx-current"
+	contextPrev      = "kubensx-prev"
 )
 
+// Options configure Context (see NewContextWithOptions).
+type Options struct {
+	// InPlace makes namespace changes apply to the named context current-context points to
+	// instead of a copy in kubensx-current, so that tools keyed on context name keep working.
+	// User/cluster changes still go to kubensx-current.
+	InPlace bool
+	// NamespaceSelector is a label selector namespaces are listed with, e.g. team=billing.
+	NamespaceSelector string
+}
+
 type context struct {
 	pre                   *k8sclientcmdapi.Context
-	acs                   k8sclientcmd.ConfigAccess
+	preName               string
+	last                  *k8sclientcmdapi.Context // what kubensx left current as of the last Commit (see Drift)
+	lastName              string
+	committed             *k8sclientcmdapi.Config // cfg as of the last Commit (see Rollback, Diff)
+	savepoints            []savepoint
 	cfg                   *k8sclientcmdapi.Config
+	commit                func(cfg k8sclientcmdapi.Config) error
 	nss                   func(user string, cluster string) ([]string, error)
 	currentContextMutated bool
+	overlay               string // session overlay path, "" if there is none (see NewOverlay)
+	opts                  Options
+}
+
+type savepoint struct {
+	cfg                   *k8sclientcmdapi.Config
+	currentContextMutated bool
 }
 
 type contextRef struct {
@@ -95,8 +132,16 @@ func (ctx *context) NamespacePrevious() string {
 
 func (ctx *context) Namespaces() ([]string, error) {
 	r, err := ctx.nss(ctx.User(), ctx.Cluster())
-	if statusError, ok := err.(*errors.StatusError); ok && statusError.ErrStatus.Code == 403 {
-		return r, nil
+	if statusError, ok := err.(*errors.StatusError); ok {
+		switch statusError.ErrStatus.Code {
+		case 403:
+			return r, nil
+		case 401:
+			return r, nsx.NewError(nsx.CodeForbidden, err)
+		}
+	}
+	if _, ok := err.(*url.Error); ok {
+		return r, nsx.NewError(nsx.CodeUnreachable, err)
 	}
 	return r, err
 }
@@ -116,6 +161,64 @@ func (ctx *context) NamespaceView() ([]string, error) {
 	return ctx.Namespaces()
 }
 
+func (ctx *context) Contexts() map[string]nsx.FQNS {
+	m := make(map[string]nsx.FQNS)
+	for key, value := range ctx.cfg.Contexts {
+		if !strings.HasPrefix(key, reservedPrefix) {
+			m[key] = nsx.FQNS{User: value.AuthInfo, Cluster: value.Cluster, NS: value.Namespace}
+		}
+	}
+	return m
+}
+
+func (ctx *context) Saved() map[string]nsx.FQNS {
+	m := make(map[string]nsx.FQNS)
+	for key := range ctx.cfg.Contexts {
+		if strings.HasPrefix(key, savedPrefix) {
+			name := strings.TrimPrefix(key, savedPrefix)
+			if value := ctx.cfg.Contexts[name]; value != nil {
+				m[name] = nsx.FQNS{User: value.AuthInfo, Cluster: value.Cluster, NS: value.Namespace}
+			}
+		}
+	}
+	return m
+}
+
+func (ctx *context) Save(name string, fqns nsx.FQNS) bool {
+	value := ctx.cfg.Contexts[name]
+	marked := ctx.cfg.Contexts[savedPrefix+name] != nil
+	if value != nil && marked &&
+		value.AuthInfo == fqns.User && value.Cluster == fqns.Cluster && value.Namespace == fqns.NS {
+		return false
+	}
+	if value == nil {
+		value = k8sclientcmdapi.NewContext()
+		ctx.cfg.Contexts[name] = value
+	}
+	value.AuthInfo, value.Cluster, value.Namespace = fqns.User, fqns.Cluster, fqns.NS
+	// marker tells Unsave (and "kubensx save --delete-all") that context can be safely deleted
+	ctx.cfg.Contexts[savedPrefix+name] = k8sclientcmdapi.NewContext()
+	return true
+}
+
+func (ctx *context) Unsave(name string) bool {
+	if ctx.cfg.Contexts[savedPrefix+name] == nil {
+		return false
+	}
+	delete(ctx.cfg.Contexts, savedPrefix+name)
+	value := ctx.cfg.Contexts[name]
+	if value == nil {
+		return false
+	}
+	if ctx.cfg.CurrentContext == name {
+		// current context is preserved (as kubensx-current)
+		ctx.cfg.Contexts[contextCurrent] = value.DeepCopy()
+		ctx.cfg.CurrentContext = contextCurrent
+	}
+	delete(ctx.cfg.Contexts, name)
+	return true
+}
+
 func (ctx *context) Associate(user string, cluster string) bool {
 	key := assocKey(user, cluster)
 	if ctx.cfg.Contexts[key] != nil {
@@ -208,18 +311,223 @@ func nsKey(user string, cluster string, namespace string) string {
 	return nsPrefix + user + assocSeparator + cluster + nsSeparator + namespace
 }
 
+func (ctx *context) Tags() map[string][]string {
+	m := make(map[string][]string)
+	for key := range ctx.cfg.Contexts {
+		if strings.HasPrefix(key, tagPrefix) {
+			pair := strings.TrimPrefix(key, tagPrefix)
+			idx := strings.LastIndex(pair, tagSeparator)
+			if idx != -1 {
+				cluster, tag := pair[:idx], pair[idx+1:]
+				if ctx.cfg.Clusters[cluster] != nil {
+					m[cluster] = append(m[cluster], tag)
+				}
+			}
+		}
+	}
+	return m
+}
+
+func (ctx *context) Tag(cluster string, tag string) bool {
+	key := tagKey(cluster, tag)
+	if ctx.cfg.Contexts[key] != nil {
+		return false
+	}
+	ctx.cfg.Contexts[key] = &k8sclientcmdapi.Context{Cluster: cluster}
+	return true
+}
+
+func (ctx *context) Untag(cluster string, tag string) bool {
+	key := tagKey(cluster, tag)
+	if ctx.cfg.Contexts[key] == nil {
+		return false
+	}
+	delete(ctx.cfg.Contexts, key)
+	return true
+}
+
+func tagKey(cluster string, tag string) string {
+	return tagPrefix + cluster + tagSeparator + tag
+}
+
+func (ctx *context) Protected() map[string][]string {
+	m := make(map[string][]string)
+	for key := range ctx.cfg.Contexts {
+		if strings.HasPrefix(key, protectPrefix) {
+			pair := strings.TrimPrefix(key, protectPrefix)
+			idx := strings.LastIndex(pair, protectSeparator)
+			if idx != -1 {
+				cluster, namespace := pair[:idx], pair[idx+1:]
+				if ctx.cfg.Clusters[cluster] != nil {
+					m[cluster] = append(m[cluster], namespace)
+				}
+			}
+		}
+	}
+	return m
+}
+
+func (ctx *context) Protect(cluster string, namespace string) bool {
+	key := protectKey(cluster, namespace)
+	if ctx.cfg.Contexts[key] != nil {
+		return false
+	}
+	ctx.cfg.Contexts[key] = &k8sclientcmdapi.Context{Cluster: cluster, Namespace: namespace}
+	return true
+}
+
+func (ctx *context) Unprotect(cluster string, namespace string) bool {
+	key := protectKey(cluster, namespace)
+	if ctx.cfg.Contexts[key] == nil {
+		return false
+	}
+	delete(ctx.cfg.Contexts, key)
+	return true
+}
+
+func protectKey(cluster string, namespace string) string {
+	return protectPrefix + cluster + protectSeparator + namespace
+}
+
+// Expiry, SetExpiry & DeleteExpiry only see the expiry of the session when overlay is in effect
+// (so that "--for" in one shell doesn't revert the others).
+
+func (ctx *context) Expiry() (nsx.FQNS, time.Time, bool) {
+	for key, value := range ctx.cfg.Contexts {
+		if ctx.isExpiry(key, value) {
+			deadline, err := time.Parse(time.RFC3339, strings.TrimPrefix(key, expiryPrefix))
+			if err != nil {
+				log.Debugf(`Ignored malformed expiry "%s"`, key)
+				continue
+			}
+			return nsx.FQNS{User: value.AuthInfo, Cluster: value.Cluster, NS: value.Namespace}, deadline, true
+		}
+	}
+	return nsx.FQNS{}, time.Time{}, false
+}
+
+func (ctx *context) SetExpiry(revertTo nsx.FQNS, deadline time.Time) {
+	ctx.DeleteExpiry()
+	ctx.cfg.Contexts[expiryPrefix+deadline.Format(time.RFC3339)] = &k8sclientcmdapi.Context{
+		LocationOfOrigin: ctx.overlay,
+		AuthInfo:         revertTo.User,
+		Cluster:          revertTo.Cluster,
+		Namespace:        revertTo.NS,
+	}
+}
+
+func (ctx *context) DeleteExpiry() bool {
+	deleted := false
+	for key, value := range ctx.cfg.Contexts {
+		if ctx.isExpiry(key, value) {
+			delete(ctx.cfg.Contexts, key)
+			deleted = true
+		}
+	}
+	return deleted
+}
+
+func (ctx *context) isExpiry(key string, value *k8sclientcmdapi.Context) bool {
+	return strings.HasPrefix(key, expiryPrefix) && (ctx.overlay == "" || value.LocationOfOrigin == ctx.overlay)
+}
+
+func (ctx *context) CurrentContext() string {
+	return currentNSXRef(ctx).key
+}
+
+func (ctx *context) Drift() (nsx.FQNS, string, bool) {
+	if ctx.last == nil {
+		// nothing has been committed yet
+		return nsx.FQNS{}, "", false
+	}
+	last := fqnsOf(ctx.last)
+	if ctx.pre != nil && ctx.preName == ctx.lastName && fqnsOf(ctx.pre) == last {
+		return nsx.FQNS{}, "", false
+	}
+	return last, ctx.lastName, true
+}
+
+func fqnsOf(ctx *k8sclientcmdapi.Context) nsx.FQNS {
+	return nsx.FQNS{User: ctx.AuthInfo, Cluster: ctx.Cluster, NS: ctx.Namespace}
+}
+
+func (ctx *context) Begin() {
+	ctx.savepoints = append(ctx.savepoints, savepoint{ctx.cfg.DeepCopy(), ctx.currentContextMutated})
+}
+
+func (ctx *context) Rollback() {
+	sp := savepoint{cfg: ctx.committed}
+	if n := len(ctx.savepoints); n != 0 {
+		sp, ctx.savepoints = ctx.savepoints[n-1], ctx.savepoints[:n-1]
+	}
+	ctx.cfg = sp.cfg.DeepCopy()
+	ctx.currentContextMutated = sp.currentContextMutated
+}
+
+func (ctx *context) Diff() (string, string, error) {
+	before, err := k8sclientcmd.Write(*ctx.committed)
+	if err != nil {
+		return "", "", err
+	}
+	cfg := ctx.cfg
+	ctx.cfg = cfg.DeepCopy()
+	defer func() { ctx.cfg = cfg }()
+	ctx.prepare()
+	after, err := k8sclientcmd.Write(*ctx.cfg)
+	if err != nil {
+		return "", "", err
+	}
+	return string(before), string(after), nil
+}
+
 func (ctx *context) Commit() error {
+	ref := ctx.prepare()
+	if reflect.DeepEqual(ctx.committed, ctx.cfg) {
+		log.Debug("Skipped writing kubeconfig (nothing has changed)")
+	} else if err := ctx.commit(*ctx.cfg); err != nil {
+		return err
+	}
+	ctx.pre, ctx.preName = ref.ctx.DeepCopy(), ref.key
+	ctx.last, ctx.lastName = ref.ctx.DeepCopy(), ref.key
+	ctx.currentContextMutated = false
+	ctx.committed, ctx.savepoints = ctx.cfg.DeepCopy(), nil
+	return nil
+}
+
+// prepare applies changes Commit makes on top of the ones made by the caller
+// (kubensx-prev, kubensx-last:<context>, purgeInvalid).
+func (ctx *context) prepare() *contextRef {
 	if ctx.currentContextMutated {
 		if ctx.pre != nil {
-			ctx.cfg.Contexts[contextPrev] = ctx.pre
+			ctx.cfg.Contexts[contextPrev] = ctx.pre.DeepCopy()
 			log.Debugf(`Set "%s" to "%s:%s/%s"`, contextPrev, ctx.pre.AuthInfo, ctx.pre.Cluster, ctx.pre.Namespace)
 		}
 		curr := ctx.cfg.Contexts[ctx.cfg.CurrentContext]
-		log.Debugf(`Set "%s" to "%s:%s/%s"`, contextCurrent, curr.AuthInfo, curr.Cluster, curr.Namespace)
+		log.Debugf(`Set "%s" to "%s:%s/%s"`, ctx.cfg.CurrentContext, curr.AuthInfo, curr.Cluster, curr.Namespace)
+	} else if _, _, drifted := ctx.Drift(); drifted {
+		// current-context was changed outside of kubensx (treated as a switch from whatever kubensx left current)
+		ctx.cfg.Contexts[contextPrev] = ctx.last.DeepCopy()
+		log.Debugf(`Set "%s" to "%s:%s/%s"`, contextPrev, ctx.last.AuthInfo, ctx.last.Cluster, ctx.last.Namespace)
+	}
+	ref := currentNSXRef(ctx)
+	// marker stays in the file it came from (e.g. session overlay)
+	var origin string
+	if marker := ctx.cfg.Contexts[lastPrefix+ref.key]; marker != nil {
+		origin = marker.LocationOfOrigin
+	}
+	for key := range ctx.cfg.Contexts {
+		if strings.HasPrefix(key, lastPrefix) {
+			delete(ctx.cfg.Contexts, key)
+		}
+	}
+	ctx.cfg.Contexts[lastPrefix+ref.key] = &k8sclientcmdapi.Context{
+		LocationOfOrigin: origin,
+		AuthInfo:         ref.ctx.AuthInfo,
+		Cluster:          ref.ctx.Cluster,
+		Namespace:        ref.ctx.Namespace,
 	}
 	ctx.purgeInvalid()
-	k8sclientcmd.ModifyConfig(ctx.acs, *ctx.cfg, false)
-	return nil
+	return ref
 }
 
 func (ctx *context) purgeInvalid() {
@@ -250,6 +558,31 @@ func (ctx *context) purgeInvalid() {
 			}
 			log.Debugf(`Deleted explicit ns "%s"`, key)
 			delete(ctx.cfg.Contexts, key)
+		} else if strings.HasPrefix(key, tagPrefix) {
+			pair := strings.TrimPrefix(key, tagPrefix)
+			idx := strings.LastIndex(pair, tagSeparator)
+			if idx != -1 && ctx.cfg.Clusters[pair[:idx]] != nil {
+				log.Debugf(`Found tag "%s"`, key)
+				continue
+			}
+			log.Debugf(`Deleted tag "%s"`, key)
+			delete(ctx.cfg.Contexts, key)
+		} else if strings.HasPrefix(key, savedPrefix) {
+			if ctx.cfg.Contexts[strings.TrimPrefix(key, savedPrefix)] != nil {
+				log.Debugf(`Found saved "%s"`, key)
+				continue
+			}
+			log.Debugf(`Deleted saved "%s"`, key)
+			delete(ctx.cfg.Contexts, key)
+		} else if strings.HasPrefix(key, protectPrefix) {
+			pair := strings.TrimPrefix(key, protectPrefix)
+			idx := strings.LastIndex(pair, protectSeparator)
+			if idx != -1 && ctx.cfg.Clusters[pair[:idx]] != nil {
+				log.Debugf(`Found protected "%s"`, key)
+				continue
+			}
+			log.Debugf(`Deleted protected "%s"`, key)
+			delete(ctx.cfg.Contexts, key)
 		}
 	}
 }
@@ -261,6 +594,14 @@ func (ctx *context) mutateCurrentNSX(cb func(ctx *k8sclientcmdapi.Context)) {
 		cb(ref.ctx)
 		return
 	}
+	if ctx.opts.InPlace && ctx.overlay == "" && !strings.HasPrefix(ref.key, reservedPrefix) {
+		probe := ref.ctx.DeepCopy()
+		cb(probe)
+		if probe.AuthInfo == ref.ctx.AuthInfo && probe.Cluster == ref.ctx.Cluster {
+			cb(ref.ctx)
+			return
+		}
+	}
 	k8sctx := &k8sclientcmdapi.Context{
 		AuthInfo:  ref.ctx.AuthInfo,
 		Cluster:   ref.ctx.Cluster,
@@ -289,6 +630,9 @@ func currentNSXRef(ctx *context) *contextRef {
 }
 
 func previousNSX(ctx *context) *k8sclientcmdapi.Context {
+	if _, _, drifted := ctx.Drift(); drifted && !ctx.currentContextMutated {
+		return ctx.last
+	}
 	r := ctx.cfg.Contexts[contextPrev]
 	if r == nil {
 		r = currentNSX(ctx)
@@ -296,7 +640,8 @@ func previousNSX(ctx *context) *k8sclientcmdapi.Context {
 	return r
 }
 
-func newContext(nss func(cfg k8sclientcmdapi.Config) func(user string, cluster string) ([]string, error)) (nsx.Context, error) {
+func newContext(nss func(cfg k8sclientcmdapi.Config) func(user string, cluster string) ([]string, error),
+	opts Options) (nsx.Context, error) {
 	clientConfig := k8sclientcmd.NewNonInteractiveDeferredLoadingClientConfig(
 		k8sclientcmd.NewDefaultClientConfigLoadingRules(),
 		&k8sclientcmd.ConfigOverrides{},
@@ -305,14 +650,66 @@ func newContext(nss func(cfg k8sclientcmdapi.Config) func(user string, cluster s
 	if err != nil {
 		return nil, err
 	}
-	ctx := cfg.Contexts[cfg.CurrentContext]
+	acs := clientConfig.ConfigAccess()
+	overlay := os.Getenv(OverlayEnv)
+	if overlay != "" {
+		acs = overlayAccess{acs, overlay}
+	}
+	// session overlay is disposable (backing it up would only push kubeconfig backups out)
+	var paths []string
+	for _, file := range files(acs) {
+		if file != overlay {
+			paths = append(paths, file)
+		}
+	}
+	ctx := wrap(&cfg, nss(cfg), func(cfg k8sclientcmdapi.Config) error {
+		if _, _, err := backup.Create(paths); err != nil {
+			return fmt.Errorf("Failed to back up kubeconfig (%s). KUBENSX_BACKUPS=0 to turn backups off", err.Error())
+		}
+		return k8sclientcmd.ModifyConfig(acs, cfg, false)
+	})
+	ctx.overlay, ctx.opts = overlay, opts
+	return ctx, nil
+}
+
+// files returns kubeconfig files acs may write to.
+func files(acs k8sclientcmd.ConfigAccess) []string {
+	if acs.IsExplicitFile() {
+		return []string{acs.GetExplicitFile()}
+	}
+	return acs.GetLoadingPrecedence()
+}
+
+func wrap(cfg *k8sclientcmdapi.Config, nss func(user string, cluster string) ([]string, error),
+	commit func(cfg k8sclientcmdapi.Config) error) *context {
+	if cfg.Contexts == nil {
+		cfg.Contexts = make(map[string]*k8sclientcmdapi.Context)
+	}
+	ctx, name := cfg.Contexts[cfg.CurrentContext], cfg.CurrentContext
+	if ctx == nil {
+		// same fallback as in currentNSXRef
+		ctx, name = cfg.Contexts[contextCurrent], contextCurrent
+	}
 	if ctx != nil {
 		ctx = ctx.DeepCopy()
 	}
-	return &context{pre: ctx, acs: clientConfig.ConfigAccess(), cfg: &cfg, nss: nss(cfg)}, nil
+	var last *k8sclientcmdapi.Context
+	var lastName string
+	for key, value := range cfg.Contexts {
+		// marker of the current context takes precedence, see NewOverlay
+		if strings.HasPrefix(key, lastPrefix) && (last == nil || key == lastPrefix+cfg.CurrentContext) {
+			last, lastName = value.DeepCopy(), strings.TrimPrefix(key, lastPrefix)
+		}
+	}
+	return &context{pre: ctx, preName: name, last: last, lastName: lastName, committed: cfg.DeepCopy(), cfg: cfg,
+		nss: nss, commit: commit}
 }
 
 func NewContext() (nsx.Context, error) {
+	return NewContextWithOptions(Options{})
+}
+
+func NewContextWithOptions(opts Options) (nsx.Context, error) {
 	// this method will have to be rewritten if ctx.Namespaces() is ever executed more than once over the course
 	// of single command execution
 	return newContext(func(cfg k8sclientcmdapi.Config) func(user string, cluster string) ([]string, error) {
@@ -330,19 +727,48 @@ func NewContext() (nsx.Context, error) {
 			if err != nil {
 				return nil, err
 			}
-			nss, err := client.CoreV1().Namespaces().List(k8smetav1.ListOptions{})
-			if err != nil {
-				return nil, err
-			}
-			acc := make([]string, 0, len(nss.Items))
-			for _, ns := range nss.Items {
-				acc = append(acc, ns.Name)
-			}
-			return acc, nil
+			return listNamespaces(client, opts.NamespaceSelector)
 		}
-	})
+	}, opts)
 }
 
 func NewContextStub(nss func(user string, cluster string) ([]string, error)) (nsx.Context, error) {
-	return newContext(func(cfg k8sclientcmdapi.Config) func(user string, cluster string) ([]string, error) { return nss })
+	return newContext(func(cfg k8sclientcmdapi.Config) func(user string, cluster string) ([]string, error) {
+		return nss
+	}, Options{})
+}
+
+// NewInMemoryContext returns Context backed by a copy of cfg (Commit writes changes back to cfg).
+// client is used to list namespaces, e.g. k8s.io/client-go/kubernetes/fake.NewSimpleClientset.
+func NewInMemoryContext(cfg *k8sclientcmdapi.Config,
+	client func(user string, cluster string) (k8s.Interface, error)) nsx.Context {
+	return NewInMemoryContextWithOptions(cfg, client, Options{})
+}
+
+func NewInMemoryContextWithOptions(cfg *k8sclientcmdapi.Config,
+	client func(user string, cluster string) (k8s.Interface, error), opts Options) nsx.Context {
+	ctx := wrap(cfg.DeepCopy(), func(user string, cluster string) ([]string, error) {
+		c, err := client(user, cluster)
+		if err != nil {
+			return nil, err
+		}
+		return listNamespaces(c, opts.NamespaceSelector)
+	}, func(c k8sclientcmdapi.Config) error {
+		*cfg = *c.DeepCopy()
+		return nil
+	})
+	ctx.opts = opts
+	return ctx
+}
+
+func listNamespaces(client k8s.Interface, selector string) ([]string, error) {
+	nss, err := client.CoreV1().Namespaces().List(k8smetav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	acc := make([]string, 0, len(nss.Items))
+	for _, ns := range nss.Items {
+		acc = append(acc, ns.Name)
+	}
+	return acc, nil
 }
@@ -0,0 +1,391 @@
+package kubectl
+
+import (
+	"errors"
+	nsx "github.com/shyiko/kubensx/context"
+	k8scorev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8s "k8s.io/client-go/kubernetes"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	k8sclientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func newConfig() *k8sclientcmdapi.Config {
+	cfg := k8sclientcmdapi.NewConfig()
+	for _, user := range []string{"alice", "bob"} {
+		cfg.AuthInfos[user] = k8sclientcmdapi.NewAuthInfo()
+	}
+	for _, cluster := range []string{"minikube", "prod"} {
+		cfg.Clusters[cluster] = k8sclientcmdapi.NewCluster()
+	}
+	cfg.Contexts["minikube"] = &k8sclientcmdapi.Context{AuthInfo: "alice", Cluster: "minikube", Namespace: "default"}
+	cfg.CurrentContext = "minikube"
+	return cfg
+}
+
+func newClientset(namespaces ...string) *k8sfake.Clientset {
+	var objects []runtime.Object
+	for _, ns := range namespaces {
+		objects = append(objects, &k8scorev1.Namespace{ObjectMeta: k8smetav1.ObjectMeta{Name: ns}})
+	}
+	return k8sfake.NewSimpleClientset(objects...)
+}
+
+func failingClientset(err error) *k8sfake.Clientset {
+	client := newClientset()
+	client.PrependReactor("list", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, err
+	})
+	return client
+}
+
+func newTestContext(cfg *k8sclientcmdapi.Config, client k8s.Interface) nsx.Context {
+	return NewInMemoryContext(cfg, func(user string, cluster string) (k8s.Interface, error) { return client, nil })
+}
+
+func TestNamespaces(t *testing.T) {
+	gr := schema.GroupResource{Resource: "namespaces"}
+	for _, test := range []struct {
+		name     string
+		client   k8s.Interface
+		expected []string
+		code     nsx.Code // 0 means no error
+	}{
+		{"listed", newClientset("kube-system", "default"), []string{"default", "kube-system"}, 0},
+		{"forbidden", failingClientset(k8serrors.NewForbidden(gr, "", errors.New("rbac"))), nil, 0},
+		{"unauthorized", failingClientset(k8serrors.NewUnauthorized("token expired")), nil, nsx.CodeForbidden},
+		{"unreachable", failingClientset(&url.Error{Op: "Get", URL: "https://127.0.0.1",
+			Err: errors.New("connection refused")}), nil, nsx.CodeUnreachable},
+		{"unknown", failingClientset(errors.New("boom")), nil, nsx.CodeUnknown},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			nss, err := newTestContext(newConfig(), test.client).Namespaces()
+			if test.code == 0 && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if test.code != 0 && nsx.CodeOf(err) != test.code {
+				t.Fatalf("expected error with code %v, got %v (%v)", test.code, nsx.CodeOf(err), err)
+			}
+			sort.Strings(nss)
+			if len(nss) != 0 || len(test.expected) != 0 {
+				if !reflect.DeepEqual(nss, test.expected) {
+					t.Fatalf("expected %v, got %v", test.expected, nss)
+				}
+			}
+		})
+	}
+}
+
+func TestNamespaceView(t *testing.T) {
+	cfg := newConfig()
+	ctx := newTestContext(cfg, newClientset("default", "kube-system"))
+	ctx.SetExplicitNamespace("alice", "minikube", "staging")
+	ctx.SetExplicitNamespace("bob", "minikube", "dev")
+	nss, err := ctx.NamespaceView()
+	if err != nil || !reflect.DeepEqual(nss, []string{"staging"}) {
+		t.Fatalf("expected explicit namespace(s) to take precedence, got %v (%v)", nss, err)
+	}
+	ctx.SetCluster("prod")
+	nss, err = ctx.NamespaceView()
+	sort.Strings(nss)
+	if err != nil || !reflect.DeepEqual(nss, []string{"default", "kube-system"}) {
+		t.Fatalf("expected namespaces listed from the cluster, got %v (%v)", nss, err)
+	}
+}
+
+func TestContexts(t *testing.T) {
+	cfg := newConfig()
+	cfg.Contexts["gke"] = &k8sclientcmdapi.Context{AuthInfo: "bob", Cluster: "prod", Namespace: "kube-system"}
+	ctx := newTestContext(cfg, newClientset())
+	ctx.Associate("alice", "minikube")
+	ctx.SetNamespace("kube-public") // creates kubensx-current
+	expected := map[string]nsx.FQNS{
+		"gke":      {User: "bob", Cluster: "prod", NS: "kube-system"},
+		"minikube": {User: "alice", Cluster: "minikube", NS: "default"},
+	}
+	if actual := ctx.Contexts(); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestSave(t *testing.T) {
+	cfg := newConfig()
+	ctx := newTestContext(cfg, newClientset())
+	dev := nsx.FQNS{User: "bob", Cluster: "prod", NS: "dev"}
+	if !ctx.Save("dev", dev) || ctx.Save("dev", dev) {
+		t.Fatal("expected Save to return true only when context is created/updated")
+	}
+	// "minikube" (not created by Save) is taken over
+	ctx.Save("minikube", nsx.FQNS{User: "alice", Cluster: "minikube", NS: "default"})
+	if len(ctx.Saved()) != 2 || ctx.Saved()["dev"] != dev {
+		t.Fatalf("unexpected %v", ctx.Saved())
+	}
+	if err := ctx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	// current context ("minikube") must survive Unsave
+	ctx = newTestContext(cfg, newClientset())
+	if !ctx.Unsave("minikube") || ctx.Unsave("minikube") {
+		t.Fatal("expected Unsave to return true only when context is deleted")
+	}
+	if current := (nsx.FQNS{User: ctx.User(), Cluster: ctx.Cluster(), NS: ctx.Namespace()}); current.User != "alice" {
+		t.Fatalf("expected current context to be preserved, got %v", current)
+	}
+	// "dev" is removed outside of kubensx (e.g. with kubectl config delete-context)
+	if err := ctx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	delete(cfg.Contexts, "dev")
+	ctx = newTestContext(cfg, newClientset())
+	if err := ctx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Contexts[savedPrefix+"dev"] != nil || cfg.Contexts[savedPrefix+"minikube"] != nil {
+		t.Fatalf("expected markers to be deleted, got %v", cfg.Contexts)
+	}
+}
+
+func TestCommit(t *testing.T) {
+	cfg := newConfig()
+	ctx := newTestContext(cfg, newClientset())
+	ctx.SetCluster("prod")
+	ctx.SetUser("bob")
+	ctx.SetNamespace("kube-system")
+	if cfg.CurrentContext != "minikube" {
+		t.Fatal("expected changes to be applied on Commit only")
+	}
+	if err := ctx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.CurrentContext != contextCurrent {
+		t.Fatalf(`expected current context to be "%s", got "%s"`, contextCurrent, cfg.CurrentContext)
+	}
+	ctx = newTestContext(cfg, newClientset())
+	current := nsx.FQNS{User: ctx.User(), Cluster: ctx.Cluster(), NS: ctx.Namespace()}
+	if expected := (nsx.FQNS{User: "bob", Cluster: "prod", NS: "kube-system"}); current != expected {
+		t.Fatalf("expected %v, got %v", expected, current)
+	}
+	previous := nsx.FQNS{User: ctx.UserPrevious(), Cluster: ctx.ClusterPrevious(), NS: ctx.NamespacePrevious()}
+	if expected := (nsx.FQNS{User: "alice", Cluster: "minikube", NS: "default"}); previous != expected {
+		t.Fatalf("expected %v, got %v", expected, previous)
+	}
+	// context that wasn't mutated must not override "previous"
+	ctx.Tag("prod", "production")
+	if err := ctx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	ctx = newTestContext(cfg, newClientset())
+	if ctx.ClusterPrevious() != "minikube" {
+		t.Fatalf(`expected previous cluster to be "minikube", got "%s"`, ctx.ClusterPrevious())
+	}
+}
+
+func TestNamespaceSelector(t *testing.T) {
+	client := k8sfake.NewSimpleClientset(
+		&k8scorev1.Namespace{ObjectMeta: k8smetav1.ObjectMeta{Name: "billing", Labels: map[string]string{"team": "billing"}}},
+		&k8scorev1.Namespace{ObjectMeta: k8smetav1.ObjectMeta{Name: "default"}},
+	)
+	ctx := NewInMemoryContextWithOptions(newConfig(), func(user string, cluster string) (k8s.Interface, error) {
+		return client, nil
+	}, Options{NamespaceSelector: "team=billing"})
+	if nss, err := ctx.Namespaces(); err != nil || !reflect.DeepEqual(nss, []string{"billing"}) {
+		t.Fatalf("expected [billing], got %v (%v)", nss, err)
+	}
+}
+
+func TestInPlace(t *testing.T) {
+	cfg := newConfig()
+	ctx := NewInMemoryContextWithOptions(cfg, func(user string, cluster string) (k8s.Interface, error) {
+		return newClientset(), nil
+	}, Options{InPlace: true})
+	ctx.SetCluster("minikube") // no-op
+	ctx.SetNamespace("kube-system")
+	if err := ctx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.CurrentContext != "minikube" || cfg.Contexts["minikube"].Namespace != "kube-system" {
+		t.Fatalf("expected minikube context to be changed in place, got %s (%+v)", cfg.CurrentContext,
+			cfg.Contexts["minikube"])
+	}
+	if prev := cfg.Contexts[contextPrev]; prev == nil || prev.Namespace != "default" {
+		t.Fatalf("expected previous namespace to be recorded, got %+v", prev)
+	}
+	ctx = newTestContext(cfg, newClientset())
+	ctx.SetUser("bob")
+	if err := ctx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.CurrentContext != contextCurrent || cfg.Contexts["minikube"].AuthInfo != "alice" {
+		t.Fatalf("expected user change to go to %s, got %s (%+v)", contextCurrent, cfg.CurrentContext,
+			cfg.Contexts["minikube"])
+	}
+}
+
+func TestDrift(t *testing.T) {
+	cfg := newConfig()
+	ctx := newTestContext(cfg, newClientset())
+	if _, _, ok := ctx.Drift(); ok {
+		t.Fatal("expected no drift before the first Commit")
+	}
+	ctx.SetNamespace("kube-system")
+	if err := ctx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := newTestContext(cfg, newClientset()).Drift(); ok {
+		t.Fatal("expected no drift right after Commit")
+	}
+	// kubectl config use-context minikube
+	cfg.CurrentContext = "minikube"
+	ctx = newTestContext(cfg, newClientset())
+	last, lastContext, ok := ctx.Drift()
+	if expected := (nsx.FQNS{User: "alice", Cluster: "minikube", NS: "kube-system"}); !ok || last != expected ||
+		lastContext != contextCurrent {
+		t.Fatalf("expected drift from %s (%v), got %s (%v) (%v)", contextCurrent, expected, lastContext, last, ok)
+	}
+	if ctx.NamespacePrevious() != "kube-system" {
+		t.Fatalf(`expected previous namespace to be "kube-system", got "%s"`, ctx.NamespacePrevious())
+	}
+	if err := ctx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	ctx = newTestContext(cfg, newClientset())
+	if _, _, ok := ctx.Drift(); ok {
+		t.Fatal("expected drift to be reconciled on Commit")
+	}
+	if ctx.NamespacePrevious() != "kube-system" {
+		t.Fatalf(`expected previous namespace to be "kube-system", got "%s"`, ctx.NamespacePrevious())
+	}
+	// kubectl config set-context --current --namespace=kube-public
+	cfg.Contexts["minikube"].Namespace = "kube-public"
+	ctx = newTestContext(cfg, newClientset())
+	if last, _, ok := ctx.Drift(); !ok || last.NS != "default" {
+		t.Fatalf("expected drift from default namespace, got %v (%v)", last, ok)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	cfg := newConfig()
+	ctx := newTestContext(cfg, newClientset())
+	ctx.Associate("alice", "minikube")
+	ctx.Begin()
+	ctx.SetUser("bob")
+	ctx.Begin()
+	ctx.SetCluster("prod")
+	ctx.Rollback()
+	if ctx.User() != "bob" || ctx.Cluster() != "minikube" {
+		t.Fatalf(`expected "bob:minikube", got "%s:%s"`, ctx.User(), ctx.Cluster())
+	}
+	ctx.Rollback()
+	if ctx.User() != "alice" || len(ctx.UsersByCluster()["minikube"]) != 1 {
+		t.Fatalf(`expected user to be "alice" (and assoc[iation] to be kept), got "%s" (%v)`, ctx.User(),
+			ctx.UsersByCluster())
+	}
+	// no Begin means "since the last Commit"
+	ctx.Rollback()
+	if len(ctx.UsersByCluster()) != 0 {
+		t.Fatalf("expected all changes to be discarded, got %v", ctx.UsersByCluster())
+	}
+	if err := ctx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.CurrentContext != "minikube" || cfg.Contexts[contextCurrent] != nil {
+		t.Fatalf("expected current context to remain unchanged, got %s", cfg.CurrentContext)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	cfg := newConfig()
+	cfg.Contexts[savedPrefix+"nope"] = &k8sclientcmdapi.Context{}
+	ctx := newTestContext(cfg, newClientset())
+	ctx.SetNamespace("kube-system")
+	before, after, err := ctx.Diff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"current-context: minikube", "name: kubensx-saved:nope"} {
+		if !strings.Contains(before, expected) {
+			t.Fatalf("expected %q in\n%s", expected, before)
+		}
+	}
+	// purgeInvalid is accounted for
+	if !strings.Contains(after, "current-context: kubensx-current") || strings.Contains(after, "kubensx-saved:nope") {
+		t.Fatalf("unexpected\n%s", after)
+	}
+	if _, ok := cfg.Contexts[savedPrefix+"nope"]; !ok || cfg.CurrentContext != "minikube" {
+		t.Fatal("expected Diff to leave cfg intact")
+	}
+	if err := ctx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if before, after, _ := ctx.Diff(); before != after {
+		t.Fatalf("expected no changes after Commit, got\n%s\n(was\n%s)", after, before)
+	}
+}
+
+func TestPurgeInvalid(t *testing.T) {
+	cfg := newConfig()
+	ctx := newTestContext(cfg, newClientset())
+	ctx.Associate("alice", "minikube")
+	ctx.Associate("bob", "prod")
+	ctx.SetExplicitNamespace("alice", "minikube", "default")
+	ctx.SetExplicitNamespace("bob", "prod", "default")
+	ctx.Tag("minikube", "local")
+	ctx.Tag("prod", "production")
+	ctx.Protect("minikube", "kube-system")
+	ctx.Protect("prod", "")
+	if err := ctx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	// "bob" & "prod" are removed outside of kubensx (e.g. with kubectl config unset)
+	delete(cfg.AuthInfos, "bob")
+	delete(cfg.Clusters, "prod")
+	ctx = newTestContext(cfg, newClientset())
+	if err := ctx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	var actual []string
+	for key := range cfg.Contexts {
+		actual = append(actual, key)
+	}
+	sort.Strings(actual)
+	expected := []string{
+		"kubensx-assoc:alice:minikube",
+		"kubensx-last:minikube",
+		"kubensx-ns:alice:minikube/default",
+		"kubensx-protected:minikube/kube-system",
+		"kubensx-tag:minikube/local",
+		"minikube",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestAssoc(t *testing.T) {
+	ctx := newTestContext(newConfig(), newClientset())
+	if !ctx.Associate("alice", "minikube") || ctx.Associate("alice", "minikube") {
+		t.Fatal("expected Associate to return true only when assoc[iation] is created")
+	}
+	ctx.Associate("bob", "minikube")
+	ctx.Associate("bob", "unknown")
+	usersByCluster := ctx.UsersByCluster()
+	sort.Strings(usersByCluster["minikube"])
+	if expected := map[string][]string{"minikube": {"alice", "bob"}}; !reflect.DeepEqual(usersByCluster, expected) {
+		t.Fatalf("expected %v, got %v", expected, usersByCluster)
+	}
+	if !ctx.Dissociate("alice", "minikube") || ctx.Dissociate("alice", "minikube") {
+		t.Fatal("expected Dissociate to return true only when assoc[iation] is deleted")
+	}
+	if expected := map[string][]string{"bob": {"minikube"}}; !reflect.DeepEqual(ctx.ClustersByUser(), expected) {
+		t.Fatalf("expected %v, got %v", expected, ctx.ClustersByUser())
+	}
+}
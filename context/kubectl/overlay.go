@@ -0,0 +1,105 @@
+package kubectl
+
+import (
+	"io/ioutil"
+	k8sclientcmd "k8s.io/client-go/tools/clientcmd"
+	k8sclientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/util/homedir"
+	"os"
+	"path/filepath"
+)
+
+// OverlayEnv is the name of the environment variable holding path to the overlay (see NewOverlay).
+const OverlayEnv = "KUBENSX_OVERLAY"
+
+// NewOverlay creates kubeconfig in ~/.kube/kubensx/overlays and returns its path.
+// Once prepended to KUBECONFIG (see WithOverlay) and exported as OverlayEnv, it makes kubensx-current,
+// kubensx-prev and the "kubensx use --for" expiry local to the session. It starts with the current context.
+// Other entries, such as assoc[iations] and tags, still go to the kubeconfig.
+// InPlace is ignored since current-context in the overlay must remain kubensx-current.
+func NewOverlay() (string, error) {
+	dir := filepath.Join(homedir.HomeDir(), ".kube", "kubensx", "overlays")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(dir, "overlay-")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	if err := writeOverlay(f.Name()); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// WithOverlay returns KUBECONFIG with overlay prepended to kubeconfig (~/.kube/config if kubeconfig is empty).
+func WithOverlay(overlay string, kubeconfig string) string {
+	if kubeconfig == "" {
+		kubeconfig = k8sclientcmd.RecommendedHomeFile
+	}
+	return overlay + string(filepath.ListSeparator) + kubeconfig
+}
+
+func writeOverlay(path string) error {
+	cfg, err := k8sclientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		k8sclientcmd.NewDefaultClientConfigLoadingRules(),
+		&k8sclientcmd.ConfigOverrides{},
+	).RawConfig()
+	if err != nil {
+		return err
+	}
+	ctx := wrap(&cfg, nil, nil)
+	dup := func(c *k8sclientcmdapi.Context) *k8sclientcmdapi.Context {
+		return &k8sclientcmdapi.Context{AuthInfo: c.AuthInfo, Cluster: c.Cluster, Namespace: c.Namespace}
+	}
+	overlay := k8sclientcmdapi.NewConfig()
+	overlay.Contexts[contextCurrent] = dup(currentNSX(ctx))
+	overlay.Contexts[contextPrev] = dup(previousNSX(ctx))
+	overlay.Contexts[lastPrefix+contextCurrent] = dup(currentNSX(ctx))
+	overlay.CurrentContext = contextCurrent
+	return k8sclientcmd.WriteToFile(*overlay, path)
+}
+
+// overlayAccess makes entries that do not exist yet go to the first kubeconfig file other than the overlay.
+type overlayAccess struct {
+	k8sclientcmd.ConfigAccess
+	overlay string
+}
+
+func (acs overlayAccess) GetDefaultFilename() string {
+	var files []string
+	for _, file := range acs.GetLoadingPrecedence() {
+		if file != acs.overlay {
+			files = append(files, file)
+		}
+	}
+	for _, file := range files {
+		if _, err := os.Stat(file); err == nil {
+			return file
+		}
+	}
+	if len(files) != 0 {
+		return files[0]
+	}
+	return acs.ConfigAccess.GetDefaultFilename()
+}
+
+// Source returns path to the kubeconfig file current-context comes from ("" if current-context isn't set).
+func Source() (string, error) {
+	acs := k8sclientcmd.NewDefaultClientConfigLoadingRules()
+	for _, file := range files(acs) {
+		cfg, err := k8sclientcmd.LoadFromFile(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", err
+		}
+		if cfg.CurrentContext != "" {
+			return file, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,101 @@
+package kubectl
+
+import (
+	"github.com/shyiko/kubensx/backup"
+	nsx "github.com/shyiko/kubensx/context"
+	"io/ioutil"
+	k8sclientcmd "k8s.io/client-go/tools/clientcmd"
+	k8sclientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOverlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubensx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(dir string) { backup.Dir = dir }(backup.Dir)
+	backup.Dir = filepath.Join(dir, "backups")
+	env := map[string]string{"HOME": dir, "KUBECONFIG": filepath.Join(dir, "config"), OverlayEnv: ""}
+	for key, value := range env {
+		defer func(key string, value string, ok bool) {
+			if ok {
+				os.Setenv(key, value)
+			} else {
+				os.Unsetenv(key)
+			}
+		}(key, os.Getenv(key), os.Getenv(key) != "")
+		os.Setenv(key, value)
+	}
+	if err := k8sclientcmd.WriteToFile(*newConfig(), env["KUBECONFIG"]); err != nil {
+		t.Fatal(err)
+	}
+	if path, err := Source(); err != nil || path != env["KUBECONFIG"] {
+		t.Fatalf("expected %q, got %q (%v)", env["KUBECONFIG"], path, err)
+	}
+	overlay, err := NewOverlay()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(overlay) != filepath.Join(dir, ".kube", "kubensx", "overlays") {
+		t.Fatalf("unexpected overlay location %q", overlay)
+	}
+	os.Setenv("KUBECONFIG", WithOverlay(overlay, env["KUBECONFIG"]))
+	os.Setenv(OverlayEnv, overlay)
+	if path, err := Source(); err != nil || path != overlay {
+		t.Fatalf("expected %q, got %q (%v)", overlay, path, err)
+	}
+	ctx, err := newContext(func(cfg k8sclientcmdapi.Config) func(user string, cluster string) ([]string, error) {
+		return func(user string, cluster string) ([]string, error) { return []string{"default", "kube-system"}, nil }
+	}, Options{InPlace: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Namespace() != "default" {
+		t.Fatalf("expected overlay to start with the current context, got %s", ctx.Namespace())
+	}
+	ctx.SetNamespace("kube-system")
+	ctx.Associate("bob", "prod")
+	ctx.SetExpiry(nsx.FQNS{User: "minikube", Cluster: "minikube", NS: "default"}, time.Now().Add(time.Minute))
+	if err := ctx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	o, err := k8sclientcmd.LoadFromFile(overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.CurrentContext != contextCurrent || o.Contexts[contextCurrent].Namespace != "kube-system" ||
+		o.Contexts[contextPrev].Namespace != "default" {
+		t.Fatalf("expected switch to be recorded in the overlay, got %+v", o)
+	}
+	cfg, err := k8sclientcmd.LoadFromFile(env["KUBECONFIG"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.CurrentContext != "minikube" || cfg.Contexts["minikube"].Namespace != "default" {
+		t.Fatalf("expected kubeconfig to be left intact (--in-place is ignored), got %s (%+v)",
+			cfg.CurrentContext, cfg.Contexts["minikube"])
+	}
+	if _, ok := cfg.Contexts[assocKey("bob", "prod")]; !ok {
+		t.Fatalf("expected assoc[iation] to go to the kubeconfig, got %v", cfg.Contexts)
+	}
+	backups, err := backup.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range backups {
+		if _, ok := b.Files[overlay]; ok {
+			t.Fatalf("expected overlay not to be backed up, got %v", b.Paths())
+		}
+	}
+	if _, _, ok := (&context{cfg: cfg}).Expiry(); ok {
+		t.Fatalf("expected expiry to stay in the overlay, got %v", cfg.Contexts)
+	}
+	if _, _, ok := (&context{cfg: o, overlay: overlay}).Expiry(); !ok {
+		t.Fatalf("expected expiry to go to the overlay, got %v", o.Contexts)
+	}
+}
@@ -0,0 +1,200 @@
+package share
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ghodss/yaml"
+	nsx "github.com/shyiko/kubensx/context"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Version of the schema produced by Export (and understood by Import).
+const Version = 1
+
+const me = "{{.Me}}"
+
+type Config struct {
+	Version int                 `json:"version"`
+	Assoc   map[string][]string `json:"assoc,omitempty"` // user -> []cluster
+	NSList  []NS                `json:"nsList,omitempty"`
+}
+
+type NS struct {
+	User      string `json:"user"`
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+}
+
+// Op is a single change applied by Import.
+type Op struct {
+	Delete  bool
+	User    string
+	Cluster string
+	NS      string // empty unless op is about ns-list
+}
+
+func (op Op) String() string {
+	sign := "+"
+	if op.Delete {
+		sign = "-"
+	}
+	if op.NS == "" {
+		return fmt.Sprintf("%s %s:%s", sign, op.User, op.Cluster)
+	}
+	return fmt.Sprintf("%s %s:%s/%s", sign, op.User, op.Cluster, op.NS)
+}
+
+// Export returns assoc[iations] and ns-list(s) with user replaced by {{.Me}} (unless user is empty).
+func Export(ctx nsx.Context, user string) *Config {
+	cfg := &Config{Version: Version, Assoc: make(map[string][]string)}
+	subst := func(value string) string {
+		if user != "" && value == user {
+			return me
+		}
+		return value
+	}
+	for u, clusters := range ctx.ClustersByUser() {
+		clusters = append([]string(nil), clusters...)
+		sort.Strings(clusters)
+		cfg.Assoc[subst(u)] = clusters
+	}
+	for _, fqns := range ctx.ExplicitNamespaces() {
+		cfg.NSList = append(cfg.NSList, NS{User: subst(fqns.User), Cluster: fqns.Cluster, Namespace: fqns.NS})
+	}
+	sort.Slice(cfg.NSList, func(i, j int) bool {
+		l, r := cfg.NSList[i], cfg.NSList[j]
+		if l.User != r.User {
+			return l.User < r.User
+		}
+		if l.Cluster != r.Cluster {
+			return l.Cluster < r.Cluster
+		}
+		return l.Namespace < r.Namespace
+	})
+	return cfg
+}
+
+// Import applies cfg to ctx ({{.Me}} is resolved to user).
+// If replace is true, assoc[iations] and ns-list(s) not present in cfg are deleted.
+// Entries referencing users/clusters that are not in ctx are skipped and returned as the second value.
+func Import(ctx nsx.Context, cfg *Config, user string, replace bool) ([]Op, []Op, error) {
+	assoc := make(map[string]bool)
+	var assocOps []Op
+	for u, clusters := range cfg.Assoc {
+		u, err := render(u, user)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, cluster := range clusters {
+			assoc[u+"\x00"+cluster] = true
+			assocOps = append(assocOps, Op{User: u, Cluster: cluster})
+		}
+	}
+	nss := make(map[nsx.FQNS]bool)
+	var nsOps []Op
+	for _, ns := range cfg.NSList {
+		u, err := render(ns.User, user)
+		if err != nil {
+			return nil, nil, err
+		}
+		nss[nsx.FQNS{User: u, Cluster: ns.Cluster, NS: ns.Namespace}] = true
+		nsOps = append(nsOps, Op{User: u, Cluster: ns.Cluster, NS: ns.Namespace})
+	}
+	var ops []Op
+	if replace {
+		for u, clusters := range ctx.ClustersByUser() {
+			for _, cluster := range clusters {
+				if !assoc[u+"\x00"+cluster] && ctx.Dissociate(u, cluster) {
+					ops = append(ops, Op{Delete: true, User: u, Cluster: cluster})
+				}
+			}
+		}
+		for _, fqns := range ctx.ExplicitNamespaces() {
+			if !nss[fqns] && ctx.DeleteExplicitNamespace(fqns.User, fqns.Cluster, fqns.NS) {
+				ops = append(ops, Op{Delete: true, User: fqns.User, Cluster: fqns.Cluster, NS: fqns.NS})
+			}
+		}
+	}
+	users, clusters := toSet(ctx.Users()), toSet(ctx.Clusters())
+	var skipped []Op
+	for _, op := range assocOps {
+		if !users[op.User] || !clusters[op.Cluster] {
+			skipped = append(skipped, op)
+		} else if ctx.Associate(op.User, op.Cluster) {
+			ops = append(ops, op)
+		}
+	}
+	for _, op := range nsOps {
+		if !users[op.User] || !clusters[op.Cluster] {
+			skipped = append(skipped, op)
+		} else if ctx.SetExplicitNamespace(op.User, op.Cluster, op.NS) {
+			ops = append(ops, op)
+		}
+	}
+	sort.SliceStable(ops, func(i, j int) bool {
+		return ops[i].Delete && !ops[j].Delete ||
+			ops[i].Delete == ops[j].Delete && ops[i].String() < ops[j].String()
+	})
+	sort.Slice(skipped, func(i, j int) bool { return skipped[i].String() < skipped[j].String() })
+	return ops, skipped, nil
+}
+
+func render(value string, user string) (string, error) {
+	if !strings.Contains(value, "{{") {
+		return value, nil
+	}
+	if user == "" {
+		return "", fmt.Errorf(`"%s" cannot be resolved (no current user)`, value)
+	}
+	t, err := template.New("").Option("missingkey=error").Parse(value)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, struct{ Me string }{user}); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func toSet(arr []string) map[string]bool {
+	m := make(map[string]bool, len(arr))
+	for _, v := range arr {
+		m[v] = true
+	}
+	return m
+}
+
+// Marshal encodes cfg as either "yaml" or "json".
+func Marshal(cfg *Config, format string) ([]byte, error) {
+	switch format {
+	case "yaml":
+		return yaml.Marshal(cfg)
+	case "json":
+		b, err := json.MarshalIndent(cfg, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(b, '\n'), nil
+	}
+	return nil, fmt.Errorf(`unsupported format "%s"`, format)
+}
+
+// Unmarshal decodes either YAML or JSON.
+func Unmarshal(b []byte) (*Config, error) {
+	var cfg Config
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Version == 0 {
+		return nil, errors.New("version is missing")
+	}
+	if cfg.Version > Version {
+		return nil, fmt.Errorf("version %d is not supported (expected %d or lower)", cfg.Version, Version)
+	}
+	return &cfg, nil
+}
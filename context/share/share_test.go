@@ -0,0 +1,72 @@
+package share
+
+import (
+	nsx "github.com/shyiko/kubensx/context"
+	"github.com/shyiko/kubensx/context/kubectl"
+	k8s "k8s.io/client-go/kubernetes"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8sclientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func newTestContext(users []string, clusters []string) nsx.Context {
+	cfg := k8sclientcmdapi.NewConfig()
+	for _, user := range users {
+		cfg.AuthInfos[user] = k8sclientcmdapi.NewAuthInfo()
+	}
+	for _, cluster := range clusters {
+		cfg.Clusters[cluster] = k8sclientcmdapi.NewCluster()
+	}
+	client := k8sfake.NewSimpleClientset()
+	return kubectl.NewInMemoryContext(cfg, func(user string, cluster string) (k8s.Interface, error) {
+		return client, nil
+	})
+}
+
+func TestRoundTrip(t *testing.T) {
+	src := newTestContext([]string{"alice", "bob"}, []string{"minikube", "prod", "staging"})
+	src.Associate("alice", "minikube")
+	src.Associate("alice", "prod")
+	src.Associate("bob", "staging")
+	src.SetExplicitNamespace("alice", "prod", "billing")
+	src.SetExplicitNamespace("bob", "staging", "default")
+	for _, format := range []string{"yaml", "json"} {
+		b, err := Marshal(Export(src, "alice"), format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := Unmarshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// "bob" and "staging" are not in the kubeconfig of the importer
+		dst := newTestContext([]string{"carol"}, []string{"minikube", "prod"})
+		ops, skipped, err := Import(dst, cfg, "carol", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var actual []string
+		for _, op := range ops {
+			actual = append(actual, op.String())
+		}
+		expected := []string{"+ carol:minikube", "+ carol:prod", "+ carol:prod/billing"}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("%s: expected %v, got %v", format, expected, actual)
+		}
+		clusters := dst.ClustersByUser()["carol"]
+		sort.Strings(clusters)
+		if !reflect.DeepEqual(clusters, []string{"minikube", "prod"}) {
+			t.Fatalf("%s: unexpected assoc[iations] %v", format, dst.ClustersByUser())
+		}
+		var actualSkipped []string
+		for _, op := range skipped {
+			actualSkipped = append(actualSkipped, op.String())
+		}
+		expectedSkipped := []string{"+ bob:staging", "+ bob:staging/default"}
+		if !reflect.DeepEqual(actualSkipped, expectedSkipped) {
+			t.Fatalf("%s: expected %v to be skipped, got %v", format, expectedSkipped, actualSkipped)
+		}
+	}
+}
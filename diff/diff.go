@@ -0,0 +1,154 @@
+// Package diff produces line-based unified diffs (as in "diff -u").
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Context is the number of unchanged lines shown around each change.
+const Context = 3
+
+type op struct {
+	kind byte // ' ', '-' or '+'
+	line string
+	a    int // index of the line in a (index of the next line of a for '+')
+	b    int // index of the line in b (index of the next line of b for '-')
+}
+
+// Unified returns unified diff of a and b ("" if there is no difference).
+func Unified(a string, b string, aName string, bName string) string {
+	if a == b {
+		return ""
+	}
+	ops := compare(lines(a), lines(b))
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", aName, bName)
+	for _, h := range hunks(ops) {
+		first := ops[h[0]]
+		aCount, bCount := 0, 0
+		for _, o := range ops[h[0]:h[1]] {
+			if o.kind != '+' {
+				aCount++
+			}
+			if o.kind != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(first.a, aCount), hunkRange(first.b, bCount))
+		for _, o := range ops[h[0]:h[1]] {
+			buf.WriteByte(o.kind)
+			buf.WriteString(o.line)
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.String()
+}
+
+func lines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// hunkRange formats start (0-based) & count the way diff -u does.
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// hunks returns [start, end) ranges of ops (changes along with up to Context lines around them).
+func hunks(ops []op) [][2]int {
+	var r [][2]int
+	for i := 0; i < len(ops); i++ {
+		if ops[i].kind == ' ' {
+			continue
+		}
+		start := i - Context
+		if start < 0 {
+			start = 0
+		}
+		// extend until there are more than 2*Context unchanged lines in a row (or ops run out)
+		end, unchanged := i, 0
+		for ; end < len(ops) && unchanged <= 2*Context; end++ {
+			if ops[end].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		if unchanged > Context {
+			end -= unchanged - Context
+		}
+		r = append(r, [2]int{start, end})
+		i = end - 1
+	}
+	return r
+}
+
+// compare returns shortest edit script turning a into b (Myers' algorithm).
+func compare(a []string, b []string) []op {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+2)
+	var trace [][]int
+loop:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break loop
+			}
+		}
+	}
+	var r []op
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			r = append(r, op{' ', a[x], x, y})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				r = append(r, op{'+', b[y], x, y})
+			} else {
+				x--
+				r = append(r, op{'-', a[x], x, y})
+			}
+		}
+	}
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return r
+}
@@ -0,0 +1,44 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	numbers := func(from int, to int, replace map[int]string) string {
+		var r []string
+		for i := from; i <= to; i++ {
+			if line, ok := replace[i]; ok {
+				if line != "" {
+					r = append(r, line)
+				}
+				continue
+			}
+			r = append(r, string(rune('a'+i-1)))
+		}
+		return strings.Join(r, "\n") + "\n"
+	}
+	for _, test := range []struct {
+		name     string
+		a        string
+		b        string
+		expected string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{"add", "", "a\n", "--- a\n+++ b\n@@ -0,0 +1 @@\n+a\n"},
+		{"delete", "a\nb\n", "a\n", "--- a\n+++ b\n@@ -1,2 +1 @@\n a\n-b\n"},
+		{"change", numbers(1, 10, nil), numbers(1, 10, map[int]string{5: "E"}),
+			"--- a\n+++ b\n@@ -2,7 +2,7 @@\n b\n c\n d\n-e\n+E\n f\n g\n h\n"},
+		{"two hunks", numbers(1, 20, nil), numbers(1, 20, map[int]string{2: "B", 19: ""}),
+			"--- a\n+++ b\n@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n@@ -16,5 +16,4 @@\n p\n q\n r\n-s\n t\n"},
+		{"one hunk", numbers(1, 12, nil), numbers(1, 12, map[int]string{2: "B", 9: "I"}),
+			"--- a\n+++ b\n@@ -1,12 +1,12 @@\n a\n-b\n+B\n c\n d\n e\n f\n g\n h\n-i\n+I\n j\n k\n l\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := Unified(test.a, test.b, "a", "b"); actual != test.expected {
+				t.Fatalf("expected\n%s\ngot\n%s", test.expected, actual)
+			}
+		})
+	}
+}
@@ -2,23 +2,36 @@ package main
 
 import (
 	"bytes"
-	"errors"
+	"encoding/json"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/fatih/color"
-	"github.com/renstrom/fuzzysearch/fuzzy"
+	"github.com/mattn/go-isatty"
+	"github.com/shyiko/kubensx/audit"
+	"github.com/shyiko/kubensx/backup"
 	"github.com/shyiko/kubensx/cli"
 	nsx "github.com/shyiko/kubensx/context"
 	nsxkubectl "github.com/shyiko/kubensx/context/kubectl"
+	"github.com/shyiko/kubensx/context/share"
+	"github.com/shyiko/kubensx/diff"
+	"github.com/shyiko/kubensx/match"
+	"github.com/shyiko/kubensx/pin"
+	"github.com/shyiko/kubensx/prompter"
+	"github.com/shyiko/kubensx/selector"
+	"github.com/shyiko/kubensx/settings"
+	"github.com/shyiko/kubensx/switcher"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
-	"gopkg.in/AlecAivazis/survey.v1"
-	surveycore "gopkg.in/AlecAivazis/survey.v1/core"
-	surveyterminal "gopkg.in/AlecAivazis/survey.v1/terminal"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 )
 
 var version string
@@ -41,38 +54,7 @@ func (f *simpleFormatter) Format(entry *log.Entry) ([]byte, error) {
 	return b.Bytes(), nil
 }
 
-func init() {
-	surveyterminal.DiscardUnsupportedEscapeSequences = true
-	// remove "? " prefix
-	survey.SelectQuestionTemplate = strings.Replace(survey.SelectQuestionTemplate,
-		`{{- color "green+hb"}}{{ QuestionIcon }} {{color "reset"}}`, "", 1)
-	survey.SelectQuestionTemplate = strings.Replace(survey.SelectQuestionTemplate,
-		`{{.Answer}}`, `{{or .Answer "\"\""}}`, 1)
-	survey.SelectQuestionTemplate = strings.Replace(survey.SelectQuestionTemplate,
-		`{{- $choice}}`, `{{- or $choice "\"\""}}`, 1)
-	survey.SelectQuestionTemplate = strings.Replace(survey.SelectQuestionTemplate,
-		`{{- "  "}}{{- color "cyan"}}[Use arrows to move, type to filter{{- if and .Help (not .ShowHelp)}}, {{ HelpInputRune }} for more help{{end}}]{{color "reset"}}`, ``, 1)
-	// remove "? " prefix
-	survey.MultiSelectQuestionTemplate = strings.Replace(survey.MultiSelectQuestionTemplate,
-		`{{- color "green+hb"}}{{ QuestionIcon }} {{color "reset"}}`, "", 1)
-	survey.MultiSelectQuestionTemplate = strings.Replace(survey.MultiSelectQuestionTemplate,
-		`{{- if .ShowAnswer}}`,
-		`{{- if not .ShowAnswer}}{{color "cyan"}} (use space to (multi)select, enter to confirm){{color "reset"}}{{end}}`+
-			`{{- if .ShowAnswer}}`, 1)
-	// "  " -> " " before option
-	survey.MultiSelectQuestionTemplate = strings.Replace(survey.MultiSelectQuestionTemplate,
-		`{{- " "}}{{$option}}`, "{{- $option}}", 1)
-	survey.InputQuestionTemplate = strings.Replace(survey.InputQuestionTemplate,
-		`{{- color "green+hb"}}{{ QuestionIcon }} {{color "reset"}}`, "", 1)
-	survey.InputQuestionTemplate = strings.Replace(survey.InputQuestionTemplate,
-		`[{{ HelpInputRune }} for help]`, "({{ .Help }})", 1)
-	survey.InputQuestionTemplate = strings.Replace(survey.InputQuestionTemplate,
-		`{{.Answer}}`, `{{or .Answer "\"\""}}`, 1)
-	surveycore.MarkedOptionIcon = "+"
-	surveycore.UnmarkedOptionIcon = " "
-}
-
-var newContext = nsxkubectl.NewContext
+var newContext = nsxkubectl.NewContextWithOptions
 
 /*
 var newContext = func () (nsx.Context, error) {
@@ -88,12 +70,12 @@ var newContext = func () (nsx.Context, error) {
 }
 */
 
-func lazyContext() func() nsx.Context {
+func lazyContext(newCtx func() (nsx.Context, error)) func() nsx.Context {
 	var ctx nsx.Context
 	return func() nsx.Context {
 		if ctx == nil {
 			var err error
-			if ctx, err = newContext(); err != nil {
+			if ctx, err = newCtx(); err != nil {
 				log.Fatal(err)
 			}
 		}
@@ -101,33 +83,120 @@ func lazyContext() func() nsx.Context {
 	}
 }
 
+// app holds state of a single execute call. It's filled in by PersistentPreRun.
+type app struct {
+	ran       bool // true once flags/arguments are parsed
+	ui        prompter.Prompter
+	noInput   bool // user cannot or does not want to be prompted (see --no-input)
+	context   nsxkubectl.Options
+	history   int                 // number of audit records to keep, 0 means all of them
+	protected map[string][]string // KUBENSX_PROTECTED
+}
+
+func newApp() *app {
+	return &app{ui: prompter.NoInput{}}
+}
+
+// newContext returns context that records switches in the audit log under the current command line.
+func (a *app) newContext() (nsx.Context, error) {
+	return a.openContext(a.context, commandLine())
+}
+
+// openContext returns context that records switches in the audit log under command.
+func (a *app) openContext(opts nsxkubectl.Options, command string) (nsx.Context, error) {
+	ctx, err := newContext(opts)
+	if err != nil {
+		return nil, err
+	}
+	return audit.Wrap(ctx, command, a.history), nil
+}
+
+func commandLine() string {
+	return strings.Join(append([]string{filepath.Base(os.Args[0])}, os.Args[1:]...), " ")
+}
+
+var newPrompter = func(noInput bool) prompter.Prompter {
+	if noInput {
+		return prompter.NoInput{}
+	}
+	return prompter.Terminal{PageSize: pageSize()}
+}
+
+// exitCodeCompletion is the exit code used when shell completion fails. Other exit codes are nsx.Codes.
+const exitCodeCompletion = 3
+
 var validNS = regexp.MustCompile(`^[a-z0-9-.]+$`)
+var validTag = regexp.MustCompile(`^#?[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+var validKey = regexp.MustCompile(`^[^\s'"]*$`)
 var whitespace = regexp.MustCompile("\\s+")
 
 func main() {
-	completion := cli.NewCompletion(lazyContext())
+	completion := cli.NewCompletion(lazyContext(newApp().newContext))
 	completed, err := completion.Execute()
 	if err != nil {
 		log.Debug(err)
-		os.Exit(3)
+		os.Exit(exitCodeCompletion)
 	}
 	if completed {
 		os.Exit(0)
 	}
+	os.Exit(execute(os.Args[1:]))
+}
+
+func execute(args []string) int {
+	a := newApp()
+	rootCmd := newRootCmd(a)
+	rootCmd.SetArgs(args)
+	if cmd, err := rootCmd.ExecuteC(); err != nil {
+		if _, ok := err.(flagError); ok || !a.ran /* flag parsing/argument validation failed */ {
+			cmd.Println("Error:", err.Error())
+			cmd.Println(cmd.UsageString())
+			return int(nsx.CodeUsage)
+		}
+		log.Error(err)
+		return int(nsx.CodeOf(err))
+	}
+	return 0
+}
+
+func newRootCmd(a *app) *cobra.Command {
 	rootCmd := &cobra.Command{
 		Use:  "kubensx",
 		Long: "Simpler Cluster/User/Namespace switching for Kubernetes (https://github.com/shyiko/kubensx).",
+		// errors are reported and mapped to exit codes once Execute returns
+		SilenceErrors: true,
+		SilenceUsage:  true,
 		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			a.ran = true
 			if debug, _ := cmd.Flags().GetBool("debug"); debug {
 				log.SetLevel(log.DebugLevel)
 			}
+			a.applySettings()
 			if kubeconfig, _ := cmd.Flags().GetString("kubeconfig"); kubeconfig != "" {
 				os.Setenv("KUBECONFIG", kubeconfig)
 			}
+			switch value := os.Getenv("KUBENSX_COLOR"); value {
+			case "", "auto":
+			case "always":
+				color.NoColor = false
+			case "never":
+				prompter.DisableColor()
+				color.NoColor = true
+			default:
+				log.Warnf(`Ignored KUBENSX_COLOR=%s (expected auto, always or never)`, value)
+			}
 			if noColor, _ := cmd.Flags().GetBool("no-color"); noColor {
-				surveycore.DisableColor = true
+				prompter.DisableColor()
 				color.NoColor = true
 			}
+			a.noInput, _ = cmd.Flags().GetBool("no-input")
+			if !cmd.Flags().Changed("no-input") && !isTerminal(os.Stdin) {
+				a.noInput = true
+			}
+			a.ui = newPrompter(a.noInput)
+			if expiring[cmd.CommandPath()] {
+				a.revertIfExpired()
+			}
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if showVersion, _ := cmd.Flags().GetBool("version"); showVersion {
@@ -142,21 +211,21 @@ func main() {
 		Aliases: []string{"a"},
 		Short:   "Assoc[iate] user with one or more clusters",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			ctx, err := newContext()
+			ctx, err := a.newContext()
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
 			dissociate, _ := cmd.Flags().GetBool("delete")
 			if dissociate && len(args) == 0 {
-				return errors.New("pattern (<user>:<cluster>) required")
+				return flagErrorf("pattern (<user>:<cluster>) required")
 			}
 			dissociateAll, _ := cmd.Flags().GetBool("delete-all")
 			if dissociateAll && len(args) != 0 {
-				return errors.New("--delete-all and pattern cannot be used together")
+				return flagErrorf("--delete-all and pattern cannot be used together")
 			}
 			if list, _ := cmd.Flags().GetBool("list"); list {
 				if dissociate || dissociateAll {
-					return errors.New("--list and --delete/--delete-all cannot be used together")
+					return flagErrorf("--list and --delete/--delete-all cannot be used together")
 				}
 				clustersByUser := ctx.ClustersByUser()
 				var users []string
@@ -174,12 +243,25 @@ func main() {
 			if len(args) == 0 && dryRun && !dissociateAll {
 				return pflag.ErrHelp
 			}
+			if ctx, err = withDiff(cmd, ctx); err != nil {
+				return err
+			}
 			if len(args) == 0 && !dryRun && !dissociateAll {
-				mustContainAtLeastOneCluster(ctx)
-				mustContainAtLeastOneUser(ctx)
-				user := prompt("user:", sortInPlace(ctx.Users()), ctx.User(), true)
-				defclusters := ctx.ClustersByUser()[user]
-				clusters := promptMultiSelect("cluster:", ctx.Clusters(), defclusters)
+				if err := requireClusters(ctx); err != nil {
+					return err
+				}
+				if err := requireUsers(ctx); err != nil {
+					return err
+				}
+				user, err := a.prompt("user:", sortInPlace(ctx.Users()), ctx.User(), true, "")
+				if err != nil {
+					return err
+				}
+				defclusters := sortInPlace(ctx.ClustersByUser()[user])
+				clusters, err := a.ui.MultiSelect("cluster:", sortInPlace(ctx.Clusters()), defclusters, clusterPreview)
+				if err != nil {
+					return err
+				}
 			nextdef:
 				for _, defcluster := range defclusters {
 					for _, cluster := range clusters {
@@ -196,22 +278,25 @@ func main() {
 					}
 				}
 			} else {
-				userMatcher := matchAll
-				clusterMatcher := matchAll
+				userMatcher := match.All
+				clusterMatcher := match.All
 				if len(args) != 0 {
 					pattern := args[0]
-					pattern, patternMatcher := newPatternMatcher(cmd, pattern)
+					pattern, patternMatcher, err := newPatternMatcher(cmd, pattern)
+					if err != nil {
+						return err
+					}
 					chunks := regexp.MustCompile(":").Split(pattern, 2)
 					if chunks[0] == "" {
-						log.Fatal("<user> cannot be empty")
+						return nsx.Errorf(nsx.CodeUsage, "<user> cannot be empty")
 					}
-					userMatcher = bindMatcher(patternMatcher, chunks[0], ctx.User())
+					userMatcher = switcher.Bind(patternMatcher, chunks[0], ctx.User())
 					if len(chunks) == 2 {
 						// must be user:cluster (not just user)
 						if chunks[1] == "" {
-							log.Fatal("<cluster> cannot be empty")
+							return nsx.Errorf(nsx.CodeUsage, "<cluster> cannot be empty")
 						}
-						clusterMatcher = bindMatcher(patternMatcher, chunks[1], ctx.Cluster())
+						clusterMatcher = switcher.BindCluster(ctx, patternMatcher, chunks[1], ctx.Cluster())
 					}
 				}
 				clusters := ctx.Clusters()
@@ -222,8 +307,8 @@ func main() {
 						return assoc[user]
 					}
 				}
-				for _, user := range sortInPlace(userMatcher(ctx.Users())) {
-					for _, cluster := range sortInPlace(clusterMatcher(clustersByUser(user))) {
+				for _, user := range sortInPlace(match.Values(userMatcher(ctx.Users()))) {
+					for _, cluster := range sortInPlace(match.Values(clusterMatcher(clustersByUser(user)))) {
 						if dissociate || dissociateAll {
 							if ctx.Dissociate(user, cluster) {
 								fmt.Printf("- %s:%s\n", user, cluster)
@@ -237,7 +322,9 @@ func main() {
 				}
 			}
 			if !dryRun {
-				ctx.Commit()
+				if err := ctx.Commit(); err != nil {
+					return err
+				}
 			}
 			return nil
 		},
@@ -252,13 +339,19 @@ func main() {
 			"  # list <user>:<cluster> pairs that would be assoc[iated] should\n" +
 			"  # `kubensx assoc <user>:<cluster>` be executed\n" +
 			"  kubensx assoc --dry-run minikube\n" +
-			"  kubensx assoc --dry-run '*:minikube'",
+			"  kubensx assoc --dry-run '*:minikube'\n" +
+			"  \n" +
+			"  # show changes kubensx is going to make to the kubeconfig (without making them)\n" +
+			"  kubensx assoc --diff minikube:minikube",
 	}
+	assocCmd.Flags().Bool("case-sensitive", false, caseSensitiveFlagUsage)
 	assocCmd.Flags().BoolP("delete", "d", false, "Delete assoc[iation](s)")
 	assocCmd.Flags().Bool("delete-all", false, "Delete all assoc[iations]")
+	assocCmd.Flags().Bool("diff", false, diffFlagUsage)
 	assocCmd.Flags().BoolP("dry-run", "x", false, "Do not modify the config (just show what's going happen)")
 	assocCmd.Flags().BoolP("exact", "e", false, "Match exactly (instead of default (wildcard) matching)")
 	assocCmd.Flags().BoolP("fuzzy", "z", false, "Match fuzzily (instead of default (wildcard) matching)")
+	assocCmd.Flags().BoolP("regex", "r", false, regexFlagUsage)
 	assocCmd.Flags().BoolP("list", "l", false, "List assoc[iations] (<user>:<cluster>|s)")
 	rootCmd.AddCommand(assocCmd)
 	assocNsCmd := &cobra.Command{
@@ -270,37 +363,54 @@ func main() {
 			"\n  - You wish to able to select a namespace from a list of options (e.g. \"kubensx use\")\nbut Access Control configuration prohibits user from listing namespaces; " +
 			"\n  - You wish to reduce number of namespaces available for selection.",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			ctx, err := newContext()
+			ctx, err := a.newContext()
 			if err != nil {
-				log.Fatal(err)
+				return err
+			}
+			if ctx, err = withDiff(cmd, ctx); err != nil {
+				return err
 			}
 			dissociate, _ := cmd.Flags().GetBool("delete")
 			if dissociate && len(args) == 0 {
-				return errors.New("pattern (<user>:<cluster>/<namespace>) required")
+				return flagErrorf("pattern (<user>:<cluster>/<namespace>) required")
 			}
 			dissociateAll, _ := cmd.Flags().GetBool("delete-all")
 			if dissociateAll && len(args) != 0 {
-				return errors.New("--delete-all and pattern cannot be used together")
+				return flagErrorf("--delete-all and pattern cannot be used together")
 			}
 			if list, _ := cmd.Flags().GetBool("list"); list {
 				if dissociate || dissociateAll {
-					return errors.New("--list and --delete/--delete-all cannot be used together")
+					return flagErrorf("--list and --delete/--delete-all cannot be used together")
 				}
 				for _, fqns := range sortFQNSSliceInPlace(ctx.ExplicitNamespaces()) {
 					fmt.Printf("%s:%s/%s\n", fqns.User, fqns.Cluster, fqns.NS)
 				}
 				return nil
 			}
-			ignoreAssoc, _ := cmd.Flags().GetBool("ignore-assoc")
+			ignoreAssoc, err := flagOrEnv(cmd, "ignore-assoc", "KUBENSX_IGNORE_ASSOC")
+			if err != nil {
+				return err
+			}
 			if len(args) == 0 && !dissociateAll {
-				mustContainAtLeastOneUser(ctx)
-				mustContainAtLeastOneCluster(ctx)
-				user := prompt("user:", sortInPlace(ctx.Users()), ctx.User(), true)
+				if err := requireUsers(ctx); err != nil {
+					return err
+				}
+				if err := requireClusters(ctx); err != nil {
+					return err
+				}
+				user, err := a.prompt("user:", sortInPlace(ctx.Users()), ctx.User(), true, "")
+				if err != nil {
+					return err
+				}
 				clusters := ctx.ClustersByUser()[user]
 				if ignoreAssoc || len(clusters) == 0 {
 					clusters = ctx.Clusters()
 				}
-				cluster := prompt("cluster:", sortInPlace(clusters), ctx.Cluster(), true)
+				cluster, err := a.promptLabeled("cluster:", sortInPlace(clusters), ctx.Cluster(), true, clusterLabel(ctx),
+					clusterPreview)
+				if err != nil {
+					return err
+				}
 				var nss []string
 				for _, r := range ctx.ExplicitNamespaces() {
 					if r.User == user && r.Cluster == cluster {
@@ -308,12 +418,15 @@ func main() {
 					}
 				}
 				sort.Strings(nss)
-				input := promptInput("namespace(s):", strings.Join(nss, " "), "space-separated")
+				input, err := a.ui.Input("namespace(s):", strings.Join(nss, " "), "space-separated")
+				if err != nil {
+					return err
+				}
 				var unss []string
 				for _, m := range whitespace.Split(input, -1) {
 					if m != "" {
 						if err := validateNS(m); err != nil {
-							log.Fatalf(err.Error())
+							return err
 						}
 						unss = append(unss, m)
 					}
@@ -344,25 +457,29 @@ func main() {
 					for _, arg := range args {
 						slashIndex := strings.LastIndex(arg, "/")
 						if slashIndex == -1 {
-							log.Fatalf(`Expected <user>:<cluster>/<namespace> or <cluster>/<namespace> (instead got "%s")`, arg)
+							return nsx.Errorf(nsx.CodeUsage,
+								`Expected <user>:<cluster>/<namespace> or <cluster>/<namespace> (instead got "%s")`, arg)
 						}
 						namespace := arg[slashIndex+1:]
 						if err := validateNS(namespace); err != nil {
-							log.Fatal(err.Error())
+							return err
+						}
+						pattern, patternMatcher, err := newPatternMatcher(cmd, arg[0:slashIndex])
+						if err != nil {
+							return err
 						}
-						pattern, patternMatcher := newPatternMatcher(cmd, arg[0:slashIndex])
 						chunks := regexp.MustCompile(":").Split(pattern, 2)
 						if len(chunks) == 1 {
 							chunks = append([]string{"*"}, chunks...)
 						}
 						if chunks[0] == "" {
-							log.Fatalf(`<user> cannot be empty ("%s")`, arg)
+							return nsx.Errorf(nsx.CodeUsage, `<user> cannot be empty ("%s")`, arg)
 						}
 						if chunks[1] == "" {
-							log.Fatalf(`<cluster> cannot be empty ("%s")`, arg)
+							return nsx.Errorf(nsx.CodeUsage, `<cluster> cannot be empty ("%s")`, arg)
 						}
-						userMatcher := bindMatcher(patternMatcher, chunks[0], ctx.User())
-						clusterMatcher := bindMatcher(patternMatcher, chunks[1], ctx.Cluster())
+						userMatcher := switcher.Bind(patternMatcher, chunks[0], ctx.User())
+						clusterMatcher := switcher.BindCluster(ctx, patternMatcher, chunks[1], ctx.Cluster())
 						clusters := ctx.Clusters()
 						assoc := ctx.ClustersByUser()
 						clustersByUser := func(user string) []string {
@@ -372,10 +489,10 @@ func main() {
 							}
 							return r
 						}
-						namespaceMatcher := bindMatcher(patternMatcher, namespace, ctx.Namespace())
-						for _, ns := range namespaceMatcher([]string{namespace}) {
-							for _, user := range userMatcher(ctx.Users()) {
-								for _, cluster := range clusterMatcher(clustersByUser(user)) {
+						namespaceMatcher := switcher.Bind(patternMatcher, namespace, ctx.Namespace())
+						for _, ns := range match.Values(namespaceMatcher([]string{namespace})) {
+							for _, user := range match.Values(userMatcher(ctx.Users())) {
+								for _, cluster := range match.Values(clusterMatcher(clustersByUser(user))) {
 									fqnss = append(fqnss, nsx.FQNS{User: user, Cluster: cluster, NS: ns})
 								}
 							}
@@ -398,7 +515,9 @@ func main() {
 			}
 			dryRun, _ := cmd.Flags().GetBool("dry-run")
 			if !dryRun {
-				ctx.Commit()
+				if err := ctx.Commit(); err != nil {
+					return err
+				}
 			}
 			return nil
 		},
@@ -413,16 +532,52 @@ func main() {
 			"  # list <user>:<cluster>/<namespace> triples that would be assoc[iated] should\n" +
 			"  # `kubensx ns-list <user>:<cluster>/<namespace>` be executed\n" +
 			"  kubensx ns-list --dry-run minikube/staging\n" +
-			"  kubensx ns-list --dry-run '*:minikube/staging'",
+			"  kubensx ns-list --dry-run '*:minikube/staging'\n" +
+			"  \n" +
+			"  # show changes kubensx is going to make to the kubeconfig (without making them)\n" +
+			"  kubensx ns-list --diff minikube/staging",
 	}
+	assocNsCmd.Flags().Bool("case-sensitive", false, caseSensitiveFlagUsage)
 	assocNsCmd.Flags().BoolP("delete", "d", false, "Delete assoc[iation](s)")
 	assocNsCmd.Flags().Bool("delete-all", false, "Delete all assoc[iations]")
+	assocNsCmd.Flags().Bool("diff", false, diffFlagUsage)
 	assocNsCmd.Flags().BoolP("dry-run", "x", false, "Do not modify the config (just show what's going happen)")
 	assocNsCmd.Flags().BoolP("exact", "e", false, "Match exactly (instead of default (wildcard) matching)")
 	assocNsCmd.Flags().BoolP("fuzzy", "z", false, "Match fuzzily (instead of default (wildcard) matching)")
+	assocNsCmd.Flags().BoolP("regex", "r", false, regexFlagUsage)
 	assocNsCmd.Flags().Bool("ignore-assoc", false, "Ignore user:cluster assoc[iations] (if any)")
 	assocNsCmd.Flags().BoolP("list", "l", false, "List assoc[iations] (<user>:<cluster>/<namespace>|s)")
 	rootCmd.AddCommand(assocNsCmd)
+	backupsCmd := &cobra.Command{
+		Use:   "backups",
+		Short: "Manage kubeconfig backups",
+		Long: "Manage kubeconfig backups\n\n" +
+			"kubeconfig file(s) are backed up to " + backup.Dir + " (KUBENSX_BACKUP_DIR to override) " +
+			"before each change kubensx makes\n(KUBENSX_BACKUPS=<number of backups to keep> (10 by default, 0 to turn backups off)).",
+	}
+	backupsLsCmd := &cobra.Command{
+		Use:   "ls",
+		Short: "List backups (newest first)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return pflag.ErrHelp
+			}
+			backups, err := backup.List()
+			if err != nil {
+				return err
+			}
+			for _, b := range backups {
+				fmt.Printf("%s %s %s\n", b.ID, color.New(color.Faint).Sprint(b.Time.Local().Format("2006-01-02 15:04:05")),
+					strings.Join(b.Paths(), ", "))
+			}
+			return nil
+		},
+		Example: "  kubensx backups ls\n" +
+			"  # restore one of them\n" +
+			"  kubensx undo 20180501T140000.000000000",
+	}
+	backupsCmd.AddCommand(backupsLsCmd)
+	rootCmd.AddCommand(backupsCmd)
 	completionCmd := &cobra.Command{
 		Use:   "completion",
 		Short: "Command-line completion",
@@ -435,7 +590,7 @@ func main() {
 				if len(args) != 0 {
 					return pflag.ErrHelp
 				}
-				if err := completion.GenBashCompletion(os.Stdout); err != nil {
+				if err := cli.NewCompletion(lazyContext(a.newContext)).GenBashCompletion(os.Stdout); err != nil {
 					log.Error(err)
 				}
 				return nil
@@ -449,23 +604,204 @@ func main() {
 				if len(args) != 0 {
 					return pflag.ErrHelp
 				}
-				if err := completion.GenZshCompletion(os.Stdout); err != nil {
+				if err := cli.NewCompletion(lazyContext(a.newContext)).GenZshCompletion(os.Stdout); err != nil {
 					log.Error(err)
 				}
 				return nil
 			},
 			Example: "  source <(kubensx completion zsh)",
 		},
+		&cobra.Command{
+			Use:   "fish",
+			Short: "Generate fish completion",
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if len(args) != 0 {
+					return pflag.ErrHelp
+				}
+				if err := cli.NewCompletion(lazyContext(a.newContext)).GenFishCompletion(os.Stdout); err != nil {
+					log.Error(err)
+				}
+				return nil
+			},
+			Example: "  kubensx completion fish | source",
+		},
 	)
 	rootCmd.AddCommand(completionCmd)
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "View/change settings, export/import assoc[iations] and ns-list(s)",
+		Long: "Settings are read from ~/.config/kubensx/config.yaml (KUBENSX_CONFIG to override).\n" +
+			"Each setting has a corresponding environment variable (which takes precedence over the file) " +
+			"(flags take precedence over both).",
+	}
+	configViewCmd := &cobra.Command{
+		Use:   "view",
+		Short: "Show effective settings",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return pflag.ErrHelp
+			}
+			for _, s := range settings.All {
+				value, source := s.Value()
+				if source == "env" {
+					fmt.Printf("%s: %s %s\n", s.Key, value, color.New(color.Faint).Sprintf("# %s", s.Env))
+				} else {
+					fmt.Printf("%s: %s\n", s.Key, value)
+				}
+			}
+			return nil
+		},
+		Example: "  kubensx config view",
+	}
+	configSetCmd := &cobra.Command{
+		Use:   "set <key> [value]",
+		Short: "Change (or -d(elete)) setting",
+		Long: "Change (or -d(elete)) setting.\n\nKeys:\n" + func() string {
+			var b bytes.Buffer
+			for _, s := range settings.All {
+				fmt.Fprintf(&b, "  %-18s %s (%s)\n", s.Key, s.Usage, s.Env)
+			}
+			return strings.TrimSuffix(b.String(), "\n")
+		}(),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			del, _ := cmd.Flags().GetBool("delete")
+			if del && len(args) != 1 || !del && len(args) != 2 {
+				return pflag.ErrHelp
+			}
+			s, ok := settings.Lookup(args[0])
+			if !ok {
+				return flagErrorf(`unknown key "%s" (see "kubensx config set --help")`, args[0])
+			}
+			if !del {
+				if err := s.Check(args[1]); err != nil {
+					return flagErrorf("%s", err.Error())
+				}
+			}
+			values, err := settings.Load()
+			if err != nil {
+				return err
+			}
+			if del {
+				delete(values, s.Key)
+			} else {
+				values[s.Key] = args[1]
+			}
+			if err := settings.Save(values); err != nil {
+				return err
+			}
+			if _, source := s.Value(); source == "env" {
+				log.Warnf("%s is overridden by %s", s.Key, s.Env)
+			}
+			return nil
+		},
+		Example: "  kubensx config set match fuzzy\n" +
+			"  kubensx config set protected prod,staging/default\n" +
+			"  kubensx config set -d match",
+	}
+	configSetCmd.Flags().BoolP("delete", "d", false, "Delete setting (revert to default)")
+	configExportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export assoc[iations] and ns-list(s)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return pflag.ErrHelp
+			}
+			ctx, err := a.newContext()
+			if err != nil {
+				return err
+			}
+			me, _ := cmd.Flags().GetString("me")
+			if me == "" {
+				me = ctx.User()
+			}
+			output, _ := cmd.Flags().GetString("output")
+			if output != "yaml" && output != "json" {
+				return flagErrorf(`--output(-o) must be either "yaml" or "json" (instead got "%s")`, output)
+			}
+			b, err := share.Marshal(share.Export(ctx, me), output)
+			if err != nil {
+				return err
+			}
+			os.Stdout.Write(b)
+			return nil
+		},
+		Example: "  kubensx config export > team.yaml\n" +
+			"  # export as JSON (any occurrence of \"jane\" user is going to be replaced with {{.Me}})\n" +
+			"  kubensx config export -o json --me jane > team.json",
+	}
+	configExportCmd.Flags().String("me", "", "User to replace with {{.Me}} (current user by default)")
+	configExportCmd.Flags().StringP("output", "o", "yaml", "Output format (yaml|json)")
+	configImportCmd := &cobra.Command{
+		Use:   "import <file>",
+		Short: "Import assoc[iations] and ns-list(s)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return pflag.ErrHelp
+			}
+			merge, _ := cmd.Flags().GetBool("merge")
+			replace, _ := cmd.Flags().GetBool("replace")
+			if merge && replace {
+				return flagErrorf("--merge and --replace cannot be used together")
+			}
+			ctx, err := a.newContext()
+			if err != nil {
+				return err
+			}
+			b, err := readFileOrStdin(args[0])
+			if err != nil {
+				return err
+			}
+			cfg, err := share.Unmarshal(b)
+			if err != nil {
+				return nsx.Errorf(nsx.CodeUsage, `Failed to parse "%s": %s`, args[0], err.Error())
+			}
+			me, _ := cmd.Flags().GetString("me")
+			if me == "" {
+				me = ctx.User()
+			}
+			ops, skipped, err := share.Import(ctx, cfg, me, replace)
+			if err != nil {
+				return err
+			}
+			for _, op := range skipped {
+				log.Warnf(`Skipped "%s" as user or cluster is not in the kubeconfig`, op)
+			}
+			for _, op := range ops {
+				fmt.Println(op)
+			}
+			if dryRun, _ := cmd.Flags().GetBool("dry-run"); !dryRun {
+				if err := ctx.Commit(); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Example: "  kubensx config import team.yaml\n" +
+			"  # drop assoc[iations]/ns-list(s) that are not present in team.yaml\n" +
+			"  kubensx config import --replace team.yaml\n" +
+			"  # show what's going to change (without modifying the config)\n" +
+			"  kubensx config import --dry-run team.yaml\n" +
+			"  cat team.yaml | kubensx config import -",
+	}
+	configImportCmd.Flags().BoolP("dry-run", "x", false, "Do not modify the config (just show what's going happen)")
+	configImportCmd.Flags().String("me", "", "User to substitute for {{.Me}} (current user by default)")
+	configImportCmd.Flags().Bool("merge", false, "Add to existing assoc[iations]/ns-list(s) (default)")
+	configImportCmd.Flags().Bool("replace", false, "Replace existing assoc[iations]/ns-list(s)")
+	configCmd.AddCommand(configExportCmd, configImportCmd, configSetCmd, configViewCmd)
+	rootCmd.AddCommand(configCmd)
 	currentCmd := &cobra.Command{
 		Use:     "current",
 		Aliases: []string{"c"},
 		Short:   "Show current context (user:cluster/namespace)",
+		Example: "  kubensx current\n" +
+			"  kubensx current -cn\n" +
+			"  kubensx current --template '{{.Cluster}}{{range .Tags}} #{{.}}{{end}}'\n" +
+			"  kubensx current --verbose\n" +
+			"  kubensx current --source",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			ctx, err := newContext()
+			ctx, err := a.newContext()
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
 			u, _ := cmd.Flags().GetBool("user")
 			c, _ := cmd.Flags().GetBool("cluster")
@@ -474,7 +810,50 @@ func main() {
 				n, _ = cmd.Flags().GetBool("ns")
 			}
 			if u && !c && n {
-				return errors.New("--cluster(-c) cannot be omitted when both --user(-u) and --namespace(--ns,-n) are present")
+				return flagErrorf("--cluster(-c) cannot be omitted when both --user(-u) and --namespace(--ns,-n) are present")
+			}
+			if source, _ := cmd.Flags().GetBool("source"); source {
+				tmpl, _ := cmd.Flags().GetString("template")
+				if verbose, _ := cmd.Flags().GetBool("verbose"); u || c || n || tmpl != "" || verbose {
+					return flagErrorf("--source cannot be combined with " +
+						"--user(-u)/--cluster(-c)/--namespace(--ns,-n)/--template/--verbose(-v)")
+				}
+				path, err := currentSource()
+				if err != nil {
+					return err
+				}
+				if path == "" {
+					return nsx.Errorf(nsx.CodeNoMatch, "current-context is not set")
+				}
+				fmt.Println(path)
+				return nil
+			}
+			if verbose, _ := cmd.Flags().GetBool("verbose"); verbose {
+				if tmpl, _ := cmd.Flags().GetString("template"); u || c || n || tmpl != "" {
+					return flagErrorf("--verbose cannot be combined with " +
+						"--user(-u)/--cluster(-c)/--namespace(--ns,-n)/--template")
+				}
+				printVerbose(ctx)
+				return nil
+			}
+			if tmpl, _ := cmd.Flags().GetString("template"); tmpl != "" {
+				if u || c || n {
+					return flagErrorf("--template cannot be combined with --user(-u)/--cluster(-c)/--namespace(--ns,-n)")
+				}
+				t, err := template.New("current").Parse(tmpl)
+				if err != nil {
+					return nsx.NewError(nsx.CodeUsage, err)
+				}
+				tags := sortInPlace(ctx.Tags()[ctx.Cluster()])
+				if err := t.Execute(os.Stdout, struct {
+					User      string
+					Cluster   string
+					Namespace string
+					Tags      []string
+				}{ctx.User(), ctx.Cluster(), ctx.Namespace(), tags}); err != nil {
+					return err
+				}
+				return nil
 			}
 			switch {
 			case u == c && c == n:
@@ -496,234 +875,1093 @@ func main() {
 	currentCmd.Flags().BoolP("cluster", "c", false, "Output cluster only (can be combined with --user(-u) and --namespace(--ns,-n))")
 	currentCmd.Flags().BoolP("namespace", "n", false, "Output namespace only (can be combined with --cluster(-c))")
 	currentCmd.Flags().Bool("ns", false, "Alias for --namespace")
+	currentCmd.Flags().Bool("source", false, "Output path to the file current context comes from "+
+		"(.kubensx if shell is in a session overlay (see \"kubensx hook --help\"), kubeconfig otherwise)")
+	currentCmd.Flags().String("template", "", "Go template to format output with "+
+		"(available fields: .User, .Cluster, .Namespace, .Tags)")
 	currentCmd.Flags().BoolP("user", "u", false, "Output user only (can be combined with --cluster(-c))")
+	currentCmd.Flags().BoolP("verbose", "v", false, "Also output the name of the current context, previous context, "+
+		"expiry (if any) and whether current-context was changed outside of kubensx")
 	rootCmd.AddCommand(currentCmd)
+	gcCmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Delete assoc[iations], ns-list(s), tags, etc. of users/clusters that no longer exist",
+		Long: "Delete assoc[iations], ns-list(s), tags, etc. of users/clusters that no longer exist.\n\n" +
+			"kubensx cleans them up on every change it makes. gc does it without changing anything else.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return pflag.ErrHelp
+			}
+			ctx, err := a.newContext()
+			if err != nil {
+				return err
+			}
+			if ctx, err = withDiff(cmd, ctx); err != nil {
+				return err
+			}
+			return ctx.Commit()
+		},
+		Example: "  # show what's going to be deleted\n" +
+			"  kubensx gc --diff\n" +
+			"  kubensx gc",
+	}
+	gcCmd.Flags().Bool("diff", false, diffFlagUsage)
+	rootCmd.AddCommand(gcCmd)
+	hookCmd := &cobra.Command{
+		Use:   "hook bash|zsh|fish",
+		Short: "Generate shell hook that switches context (of the current shell only) on cd according to .kubensx",
+		Long: "Generate shell hook that switches context (of the current shell only) on cd according to .kubensx." +
+			"\n\n.kubensx (in the current directory or any of its parents) pins user:cluster/namespace (or @context)" +
+			"\nfor the project (pattern must match exactly one context)." +
+			"\nOnce the hook is in place, entering such directory puts shell into a session overlay (a kubeconfig" +
+			"\nprepended to KUBECONFIG) so that the switch (and any \"kubensx use\" made afterwards) affects current shell" +
+			"\nonly. Leaving the directory (tree) restores KUBECONFIG.",
+		Example: "  # ~/.bashrc\n" +
+			"  eval \"$(kubensx hook bash)\"\n" +
+			"  # ~/.zshrc\n" +
+			"  eval \"$(kubensx hook zsh)\"\n" +
+			"  # ~/.config/fish/config.fish\n" +
+			"  kubensx hook fish | source",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return pflag.ErrHelp
+			}
+			if env, _ := cmd.Flags().GetBool("env"); env {
+				return a.hookEnv(os.Stdout, args[0])
+			}
+			gen := map[string]func(io.Writer) error{
+				"bash": cli.GenBashHook,
+				"zsh":  cli.GenZshHook,
+				"fish": cli.GenFishHook,
+			}[args[0]]
+			if gen == nil {
+				return flagErrorf(`unsupported shell "%s" (expected bash, zsh or fish)`, args[0])
+			}
+			return gen(os.Stdout)
+		},
+	}
+	hookCmd.Flags().Bool("env", false, "Print statements (to be evaluated by the shell) that enter/leave session overlay "+
+		"according to .kubensx\n(invoked by the hook whenever working directory changes)")
+	rootCmd.AddCommand(hookCmd)
+	initCmd := &cobra.Command{
+		Use:   "init bash|zsh|fish",
+		Short: "Generate shell integration (completion, session-scoped context, prompt segment & key binding)",
+		Long: "Generate shell integration:" +
+			"\n- completion (same as \"kubensx completion <shell>\")," +
+			"\n- session: shell gets a kubeconfig overlay (prepended to KUBECONFIG) of its own" +
+			"\n  (starting with the current context) so that \"kubensx use\" affects current shell only" +
+			"\n  (overlay is deleted on exit; see also \"kubensx hook --help\")," +
+			"\n- kubensx function (wrapping the binary) that keeps kubensx_ps1 prompt segment (\"(<cluster>/<namespace>) \") " +
+			"up to date," +
+			"\n- key binding that opens the picker (\"kubensx use\").",
+		Example: "  # ~/.bashrc\n" +
+			"  eval \"$(kubensx init bash --prompt)\"\n" +
+			"  # ~/.zshrc\n" +
+			"  eval \"$(kubensx init zsh --prompt)\"\n" +
+			"  # ~/.config/fish/config.fish\n" +
+			"  kubensx init fish --prompt | source\n" +
+			"  # prompt segment can also be placed manually\n" +
+			"  PS1='$(kubensx_ps1)\\$ '",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return pflag.ErrHelp
+			}
+			if env, _ := cmd.Flags().GetBool("env"); env {
+				return initEnv(os.Stdout, args[0])
+			}
+			var opts cli.InitOptions
+			opts.Prompt, _ = cmd.Flags().GetBool("prompt")
+			opts.Key, _ = cmd.Flags().GetString("key")
+			if !validKey.MatchString(opts.Key) {
+				return flagErrorf(`--key: "%s" is not a valid key sequence (expected something like \ek)`, opts.Key)
+			}
+			c := cli.NewCompletion(lazyContext(a.newContext))
+			gen := map[string]func(io.Writer, cli.InitOptions) error{
+				"bash": c.GenBashInit,
+				"zsh":  c.GenZshInit,
+				"fish": c.GenFishInit,
+			}[args[0]]
+			if gen == nil {
+				return flagErrorf(`unsupported shell "%s" (expected bash, zsh or fish)`, args[0])
+			}
+			return gen(os.Stdout, opts)
+		},
+	}
+	initCmd.Flags().Bool("env", false, "Print statements (to be evaluated by the shell) that start a new session "+
+		"(invoked by the init script)")
+	initCmd.Flags().String("key", `\ek`, "Key sequence that opens the picker (\\ek is Alt+k; \"\" to disable)")
+	initCmd.Flags().Bool("prompt", false, "Prepend kubensx_ps1 (\"(<cluster>/<namespace>) \") to the prompt")
+	rootCmd.AddCommand(initCmd)
 	lsCmd := &cobra.Command{
 		Use:     "ls",
 		Aliases: []string{"l"},
-		Short:   "List users/clusters/namespaces",
+		Short:   "List users/clusters/namespaces/contexts",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			u, _ := cmd.Flags().GetBool("users")
 			c, _ := cmd.Flags().GetBool("clusters")
 			n, _ := cmd.Flags().GetBool("namespaces")
-			ignoreExplicitNS, _ := cmd.Flags().GetBool("ignore-ns-list")
-			if !u && !c && !n {
+			x, _ := cmd.Flags().GetBool("contexts")
+			ignoreExplicitNS, err := flagOrEnv(cmd, "ignore-ns-list", "KUBENSX_IGNORE_NS_LIST")
+			if err != nil {
+				return err
+			}
+			tags, _ := cmd.Flags().GetStringSlice("tag")
+			if !u && !c && !n && !x {
 				return pflag.ErrHelp
 			}
-			if u && c || u && n || c && n {
-				return errors.New("--users(-u)/--clusters(-c)/--namespaces(-n) cannot be used together")
+			if u && c || u && n || c && n || x && (u || c || n) {
+				return flagErrorf("--users(-u)/--clusters(-c)/--namespaces(-n)/--contexts cannot be used together")
 			}
-			ctx, err := newContext()
+			if len(tags) != 0 && !c {
+				return flagErrorf("--tag can only be used together with --clusters(-c)")
+			}
+			ctx, err := a.newContext()
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
 			switch {
+			case x:
+				contexts := ctx.Contexts()
+				printWithSelectionHighlighted(keys(contexts), contextNameOf(contexts, switcher.Current(ctx)))
 			case u:
 				printWithSelectionHighlighted(ctx.Users(), ctx.User())
 			case c:
-				printWithSelectionHighlighted(ctx.Clusters(), ctx.Cluster())
+				printWithSelectionHighlighted(filterByTags(ctx, ctx.Clusters(), tags), ctx.Cluster())
 			case n:
-				printWithSelectionHighlighted(requireNamespaces(ctx, !ignoreExplicitNS), ctx.Namespace())
+				nss, err := switcher.Namespaces(ctx, ctx.User(), ctx.Cluster(), !ignoreExplicitNS)
+				if err != nil {
+					return err
+				}
+				printWithSelectionHighlighted(nss, ctx.Namespace())
 			}
 			return nil
 		},
 	}
 	lsCmd.Flags().BoolP("clusters", "c", false, "List clusters")
+	lsCmd.Flags().Bool("contexts", false, "List named contexts (current one (if any) is highlighted)")
 	lsCmd.Flags().BoolP("namespaces", "n", false, "List namespaces")
 	lsCmd.Flags().BoolP("users", "u", false, "List users")
 	lsCmd.Flags().Bool("ignore-ns-list", false, "Ignore explicit user:cluster/namespace(s) (if any)")
+	lsCmd.Flags().StringSlice("tag", nil, "List only clusters tagged with (all of the) given tag(s) (can be repeated)")
 	rootCmd.AddCommand(lsCmd)
-	useCmd := &cobra.Command{
-		Use:     "use [user:cluster/namespace]",
-		Aliases: []string{"u"},
-		Short:   "Change context",
+	logCmd := &cobra.Command{
+		Use:   "log",
+		Short: "Show context switch history",
+		Long: "Show context switch history\n\n" +
+			"Each switch (made with kubensx) is recorded to " + audit.Path + " (KUBENSX_AUDIT_LOG to override).",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			ctx, err := newContext()
-			if err != nil {
-				log.Fatal(err)
+			if len(args) != 0 {
+				return pflag.ErrHelp
 			}
-			u, _ := cmd.Flags().GetBool("user")
-			c, _ := cmd.Flags().GetBool("cluster")
-			n, _ := cmd.Flags().GetBool("namespace")
-			if !n {
-				n, _ = cmd.Flags().GetBool("ns")
+			var since time.Time
+			if value, _ := cmd.Flags().GetString("since"); value != "" {
+				var err error
+				if since, err = parseSince(value, time.Now()); err != nil {
+					return err
+				}
 			}
-			if !u && !c && !n {
-				u, c, n = true, true, true
+			output, _ := cmd.Flags().GetString("output")
+			if output != "" && output != "json" {
+				return flagErrorf(`--output(-o) must be "json" (instead got "%s")`, output)
 			}
-			dryRun, _ := cmd.Flags().GetBool("dry-run")
-			ignoreAssoc, _ := cmd.Flags().GetBool("ignore-assoc")
-			ignoreExplicitNS, _ := cmd.Flags().GetBool("ignore-ns-list")
-			force, _ := cmd.Flags().GetBool("force")
-			if len(args) == 0 {
-				mustContainAtLeastOneCluster(ctx)
-				mustContainAtLeastOneUser(ctx)
-				ctx.SetCluster(prompt("cluster:", sortInPlace(ctx.Clusters()), ctx.Cluster(), c))
-				users, user := sortInPlace(ctx.Users()), ctx.User()
-				if !ignoreAssoc {
-					assoc := ctx.UsersByCluster()[ctx.Cluster()]
-					if len(assoc) != 0 {
-						users = sortInPlace(assoc)
-						if index(users, user) == -1 {
-							user = users[0]
-						}
-					}
-				}
-				ctx.SetUser(prompt("user:", users, user, u))
-				nss := requireNamespaces(ctx, !ignoreExplicitNS)
-				if len(nss) == 0 {
-					fmt.Println("\nIt appears that the user you have selected is not allowed to list namespaces.\n" +
-						"If you wish to avoid manual entry next time you `kubensx use` - see `kubensx ns-list --help`.\n")
-					ns := promptInput("namespace:", "", "")
-					if err := validateNS(ns); err != nil {
-						log.Fatalf(err.Error())
+			records, err := audit.Read(since)
+			if err != nil {
+				return err
+			}
+			for _, r := range records {
+				if output == "json" {
+					b, err := json.Marshal(r)
+					if err != nil {
+						return err
 					}
-					ctx.SetNamespace(ns)
-				} else {
-					ctx.SetNamespace(prompt("namespace:", sortInPlace(nss), ctx.Namespace(), n))
+					fmt.Println(string(b))
+					continue
 				}
-			} else if args[0] == "-" {
-				ctx.SetCluster(ctx.ClusterPrevious())
-				ctx.SetUser(ctx.UserPrevious())
-				ctx.SetNamespace(ctx.NamespacePrevious())
-			} else {
-				pattern := args[0]
-				pattern, patternMatcher := newPatternMatcher(cmd, pattern)
-				var user, cluster, namespace string
-				var uexp, nexp bool // user/cluster/namespace explicit
-				if !(u && c && n) {
-					if u && c || u && n || c && n {
-						return errors.New("--user(-u)/--cluster(-c)/--namespace(--ns,-n) cannot be used together")
+				fmt.Printf("%s %s -> %s (%s)\n", r.Time.Local().Format("2006-01-02 15:04:05"),
+					formatFQNS(r.From), color.CyanString(formatFQNS(r.To)), r.Command)
+			}
+			return nil
+		},
+		Example: "  kubensx log\n" +
+			"  # switches made in the last hour\n" +
+			"  kubensx log --since 1h\n" +
+			"  # switches made since 14:00 (today)\n" +
+			"  kubensx log --since 14:00\n" +
+			"  kubensx log --since 2018-05-01",
+	}
+	logCmd.Flags().StringP("output", "o", "", "Output format (json)")
+	logCmd.Flags().String("since", "", "Show switches made since given time (e.g. 1h, 14:00, 2018-05-01, 2018-05-01T14:00:00Z)")
+	rootCmd.AddCommand(logCmd)
+	materializeCmd := &cobra.Command{
+		Use:   "materialize --assoc",
+		Short: "Create named context for each assoc[iated] user:cluster",
+		Long: "Create named context for each assoc[iated] user:cluster\n\n" +
+			"Contexts are named according to --template " +
+			"(available fields: .User, .Cluster, .Tags) and can be deleted with \"kubensx materialize --assoc -d\" " +
+			"(or \"kubensx save --delete-all\").\n" +
+			"Namespace of the context that was materialized before is kept as is (\"default\" is used otherwise).",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return flagErrorf("unexpected argument(s): %s", strings.Join(args, " "))
+			}
+			if assoc, _ := cmd.Flags().GetBool("assoc"); !assoc {
+				return pflag.ErrHelp
+			}
+			tmpl, _ := cmd.Flags().GetString("template")
+			t, err := template.New("name").Parse(tmpl)
+			if err != nil {
+				return nsx.NewError(nsx.CodeUsage, err)
+			}
+			ctx, err := a.newContext()
+			if err != nil {
+				return err
+			}
+			unsave, _ := cmd.Flags().GetBool("delete")
+			overwrite, _ := cmd.Flags().GetBool("overwrite")
+			saved := ctx.Saved()
+			contexts := ctx.Contexts()
+			tags := ctx.Tags()
+			clustersByUser := ctx.ClustersByUser()
+			var users []string
+			for user := range clustersByUser {
+				users = append(users, user)
+			}
+			var names []string
+			var pairs []nsx.FQNS
+			owners := make(map[string]nsx.FQNS)
+			for _, user := range sortInPlace(users) {
+				for _, cluster := range sortInPlace(clustersByUser[user]) {
+					var b bytes.Buffer
+					if err := t.Execute(&b, struct {
+						User    string
+						Cluster string
+						Tags    []string
+					}{user, cluster, sortInPlace(tags[cluster])}); err != nil {
+						return nsx.NewError(nsx.CodeUsage, err)
 					}
-					user, cluster, namespace = ctx.User(), ctx.Cluster(), ctx.Namespace()
-					switch {
-					case u:
-						uexp = true
-						user = pattern
-					case c:
-						cluster = pattern
-					case n:
-						nexp = true
-						namespace = pattern
+					name := b.String()
+					if err := validateContextName(name); err != nil {
+						return err
 					}
-				} else {
-					chunks := regexp.MustCompile("[:/]").Split(pattern, 3)
-					switch len(chunks) {
-					case 3: // user:cluster/namespace
-						user, cluster, namespace = chunks[0], chunks[1], chunks[2]
-						uexp, nexp = true, true
-					case 2: // user:cluster or cluster/namespace
-						if pattern[len(chunks[0])] == ':' {
-							user, cluster, namespace = chunks[0], chunks[1], ctx.Namespace()
-							uexp = true
-						} else {
-							user, cluster, namespace = ctx.User(), chunks[0], chunks[1]
-							nexp = true
-						}
-					case 1: // namespace
-						user, cluster, namespace = ctx.User(), ctx.Cluster(), chunks[0]
-						nexp = true
+					pair := nsx.FQNS{User: user, Cluster: cluster}
+					if owner, ok := owners[name]; ok && !unsave {
+						return nsx.Errorf(nsx.CodeUsage, `--template produces "%s" for both %s:%s and %s:%s`,
+							name, owner.User, owner.Cluster, user, cluster)
 					}
+					owners[name] = pair
+					names, pairs = append(names, name), append(pairs, pair)
 				}
-				log.Debugf(`Searching for "%s(%v):%s/%s(%v)"`, user, uexp, cluster, namespace, nexp)
-				// user and cluster can be empty per
-				// https://kubernetes.io/docs/concepts/configuration/organize-cluster-access-kubeconfig/
-				// (same goes for namespace)
-				// have said that said, "" (empty) option should not be available for selection (through "prompt")
-				clusterMatcher := stableMatcher(bindMatcher(patternMatcher, cluster, ctx.Cluster()))
-				if cluster == "" {
-					clusterMatcher = allowEmpty(clusterMatcher)
+			}
+			for i, name := range names {
+				if unsave {
+					if ctx.Unsave(name) {
+						fmt.Printf("- %s\n", name)
+					}
+					continue
 				}
-				userMatcher := stableMatcher(bindMatcher(patternMatcher, user, ctx.User()))
-				if user == "" {
-					userMatcher = allowEmpty(userMatcher)
+				fqns := nsx.FQNS{User: pairs[i].User, Cluster: pairs[i].Cluster, NS: "default"}
+				if prev, ok := saved[name]; ok && prev.User == fqns.User && prev.Cluster == fqns.Cluster {
+					fqns.NS = prev.NS
 				}
-				namespaceMatcher := stableMatcher(bindMatcher(patternMatcher, namespace, ctx.Namespace()))
-				boundNSS := func() []string {
-					if force {
-						return []string{namespace}
-					}
-					r := requireNamespaces(ctx, !ignoreExplicitNS)
-					if len(r) == 0 {
-						log.Fatalf("It appears that \"%s\" is not allowed to list namespaces in \"%s\" cluster.\n"+
-							"Either use --force(-f) (in which case namespace must be specified --exact|ly) or "+
-							"provide an explicit list of namespaces via `kubensx ns-list`.", ctx.User(), ctx.Cluster())
+				if _, ok := contexts[name]; ok {
+					if _, ok := saved[name]; !ok && !overwrite {
+						log.Warnf(`Skipped "%s" (context already exists (--overwrite is required to replace context `+
+							`not created by kubensx))`, name)
+						continue
 					}
-					return r
 				}
-				if namespace == "" {
-					namespaceMatcher = allowEmpty(namespaceMatcher)
-					boundNSS = func() []string { return []string{""} }
+				if ctx.Save(name, fqns) {
+					fmt.Printf("+ %s (%s)\n", name, formatFQNS(fqns))
+				}
+			}
+			if dryRun, _ := cmd.Flags().GetBool("dry-run"); !dryRun {
+				if err := ctx.Commit(); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Example: "  # create <user>@<cluster> context for each assoc[iated] user:cluster\n" +
+			"  kubensx materialize --assoc\n" +
+			"  kubensx materialize --assoc --template '{{.Cluster}}'\n" +
+			"  \n" +
+			"  # delete contexts created with kubensx materialize --assoc\n" +
+			"  kubensx materialize --assoc -d",
+	}
+	materializeCmd.Flags().Bool("assoc", false, "Create context for each assoc[iated] user:cluster (see \"kubensx assoc --help\")")
+	materializeCmd.Flags().BoolP("delete", "d", false, "Delete contexts (created by kubensx materialize before)")
+	materializeCmd.Flags().BoolP("dry-run", "x", false, "Do not modify the config (just show what's going happen)")
+	materializeCmd.Flags().Bool("overwrite", false, "Replace context(s) even if they weren't created by kubensx")
+	materializeCmd.Flags().String("template", "{{.User}}@{{.Cluster}}", "Go template to name contexts with "+
+		"(available fields: .User, .Cluster, .Tags)")
+	rootCmd.AddCommand(materializeCmd)
+	protectCmd := &cobra.Command{
+		Use:   "protect [cluster[/namespace]]",
+		Short: "Require confirmation when switching to cluster(s)/namespace(s)",
+		Long: "Require confirmation when switching to cluster(s)/namespace(s)\n\n" +
+			"\"kubensx use\" asks to type the name of a protected cluster before switching to it " +
+			"(unless --yes(-y) is given).\nWhen stdin is not a terminal, --yes(-y) is required.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, err := a.newContext()
+			if err != nil {
+				return err
+			}
+			unprotect, _ := cmd.Flags().GetBool("delete")
+			unprotectAll, _ := cmd.Flags().GetBool("delete-all")
+			if unprotectAll && len(args) != 0 {
+				return flagErrorf("--delete-all and pattern cannot be used together")
+			}
+			protected := ctx.Protected()
+			if list, _ := cmd.Flags().GetBool("list"); list {
+				if unprotect || unprotectAll {
+					return flagErrorf("--list and --delete/--delete-all cannot be used together")
+				}
+				var clusters []string
+				for cluster := range protected {
+					clusters = append(clusters, cluster)
 				}
-				if !nexp {
-					namespaceMatcher = fallbackToAllAvailable(namespaceMatcher)
+				for _, cluster := range sortInPlace(clusters) {
+					for _, namespace := range sortInPlace(protected[cluster]) {
+						fmt.Println(formatProtected(cluster, namespace))
+					}
 				}
-				assoc := ctx.UsersByCluster()
-				usersByCluster := func(cluster string) []string {
-					if ignoreAssoc {
-						return ctx.Users()
+				return nil
+			}
+			if len(args) == 0 && !unprotectAll {
+				return pflag.ErrHelp
+			}
+			clusterMatcher := match.All
+			var namespace string
+			namespaceExplicit := false
+			if len(args) != 0 {
+				pattern := args[0]
+				if slashIndex := strings.LastIndex(pattern, "/"); slashIndex != -1 {
+					pattern, namespace = pattern[:slashIndex], pattern[slashIndex+1:]
+					if namespace == "*" {
+						namespace = ""
 					}
-					users := assoc[cluster]
-					if len(users) == 0 {
-						users = ctx.Users()
+					if namespace != "" {
+						if err := validateNS(namespace); err != nil {
+							return err
+						}
 					}
-					return users
+					namespaceExplicit = true
 				}
-				if !uexp {
-					userMatcher = fallbackToAllAvailable(userMatcher)
+				pattern, patternMatcher, err := newPatternMatcher(cmd, pattern)
+				if err != nil {
+					return err
 				}
-				if dryRun {
-					for _, cluster := range clusterMatcher(ctx.Clusters()) {
-						ctx.SetCluster(cluster)
-						for _, user := range userMatcher(usersByCluster(cluster)) {
-							ctx.SetUser(user)
-							for _, namespace := range namespaceMatcher(boundNSS()) {
-								ctx.SetNamespace(namespace)
-								fmt.Println(formatContext(ctx))
-							}
+				if pattern == "" {
+					return nsx.Errorf(nsx.CodeUsage, `<cluster> cannot be empty ("%s")`, args[0])
+				}
+				clusterMatcher = switcher.BindCluster(ctx, patternMatcher, pattern, ctx.Cluster())
+			}
+			for _, cluster := range sortInPlace(match.Values(clusterMatcher(ctx.Clusters()))) {
+				if unprotect || unprotectAll {
+					namespaces := []string{namespace}
+					if !namespaceExplicit {
+						namespaces = protected[cluster]
+					}
+					for _, namespace := range sortInPlace(namespaces) {
+						if ctx.Unprotect(cluster, namespace) {
+							fmt.Println("- " + formatProtected(cluster, namespace))
 						}
 					}
-					return nil
+				} else {
+					if ctx.Protect(cluster, namespace) {
+						fmt.Println("+ " + formatProtected(cluster, namespace))
+					}
 				}
-				mustContainAtLeastOneCluster(ctx)
-				mustContainAtLeastOneUser(ctx)
-				promptPattern := func(msg string, opts []string, def string, pattern string, matcher matcher) string {
-					matches := matcher(opts)
-					switch len(matches) {
-					case 0:
-						log.Fatalf(`"%s" does not match any of the %ss (expected one of (%s))`,
-							pattern, msg, strings.Join(opts, ", "))
-					case 1:
-						return matches[0]
-					}
-					var opt = def
-					if index(matches, def) == -1 {
-						opt = matches[0]
-					}
-					match := prompt(msg+":", matches, opt, true)
-					erasePreviousLine()
-					return match
-				}
-				ctx.SetCluster(promptPattern("cluster", ctx.Clusters(), ctx.Cluster(), cluster, clusterMatcher))
-				ctx.SetUser(promptPattern("user", usersByCluster(ctx.Cluster()), ctx.User(), user, userMatcher))
-				ctx.SetNamespace(promptPattern("namespace", boundNSS(), ctx.Namespace(), namespace, namespaceMatcher))
 			}
-			if !dryRun {
-				ctx.Commit()
+			if dryRun, _ := cmd.Flags().GetBool("dry-run"); !dryRun {
+				if err := ctx.Commit(); err != nil {
+					return err
+				}
 			}
-			fmt.Println("Switched to " + formatContext(ctx))
 			return nil
 		},
+		Example: "  # protect all clusters tagged as prod\n" +
+			"  kubensx protect '#prod'\n" +
+			"  # protect kube-system namespace in minikube cluster\n" +
+			"  kubensx protect minikube/kube-system\n" +
+			"  \n" +
+			"  # list protected cluster(s)/namespace(s)\n" +
+			"  kubensx protect -l\n" +
+			"  \n" +
+			"  # unprotect minikube cluster (including any of the namespaces)\n" +
+			"  kubensx protect -d minikube",
 	}
-	useCmd.Flags().BoolP("cluster", "c", false, "Change cluster only")
-	useCmd.Flags().BoolP("dry-run", "x", false, "List matches (without changing the context)")
+	protectCmd.Flags().Bool("case-sensitive", false, caseSensitiveFlagUsage)
+	protectCmd.Flags().BoolP("delete", "d", false, "Unprotect cluster(s)/namespace(s)")
+	protectCmd.Flags().Bool("delete-all", false, "Unprotect everything")
+	protectCmd.Flags().BoolP("dry-run", "x", false, "Do not modify the config (just show what's going happen)")
+	protectCmd.Flags().BoolP("exact", "e", false, "Match exactly (instead of default (wildcard) matching)")
+	protectCmd.Flags().BoolP("fuzzy", "z", false, "Match fuzzily (instead of default (wildcard) matching)")
+	protectCmd.Flags().BoolP("regex", "r", false, regexFlagUsage)
+	protectCmd.Flags().BoolP("list", "l", false, "List protected cluster(s)/namespace(s)")
+	rootCmd.AddCommand(protectCmd)
+	saveCmd := &cobra.Command{
+		Use:   "save [name] [pattern]",
+		Short: "Save current (or matching) user:cluster/namespace as a named context",
+		Long: "Save current (or matching) user:cluster/namespace as a named context\n\n" +
+			"Unlike kubensx-current, named contexts are visible to other tools (k9s, Lens, IDE plugins, etc).\n" +
+			"Contexts created with \"kubensx save\" (or \"kubensx materialize\") can be deleted with " +
+			"\"kubensx save -d <name>\" (or \"kubensx save --delete-all\").",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, err := a.newContext()
+			if err != nil {
+				return err
+			}
+			unsave, _ := cmd.Flags().GetBool("delete")
+			if unsave && len(args) == 0 {
+				return flagErrorf("<name> required")
+			}
+			unsaveAll, _ := cmd.Flags().GetBool("delete-all")
+			if unsaveAll && len(args) != 0 {
+				return flagErrorf("--delete-all and <name> cannot be used together")
+			}
+			saved := ctx.Saved()
+			if list, _ := cmd.Flags().GetBool("list"); list {
+				if unsave || unsaveAll {
+					return flagErrorf("--list and --delete/--delete-all cannot be used together")
+				}
+				label := contextLabel(saved)
+				for _, name := range sortInPlace(keys(saved)) {
+					fmt.Println(label(name))
+				}
+				return nil
+			}
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			if unsave || unsaveAll {
+				names := args
+				if unsaveAll {
+					names = keys(saved)
+				}
+				for _, name := range sortInPlace(names) {
+					if _, ok := saved[name]; !ok {
+						return nsx.Errorf(nsx.CodeNoMatch, `"%s" is not one of the contexts created by kubensx (expected one of (%s))`,
+							name, strings.Join(sortInPlace(keys(saved)), ", "))
+					}
+				}
+				for _, name := range names {
+					if ctx.Unsave(name) {
+						fmt.Printf("- %s\n", name)
+					}
+				}
+			} else {
+				if len(args) == 0 {
+					return pflag.ErrHelp
+				}
+				if len(args) > 2 {
+					return flagErrorf("expected <name> [pattern]")
+				}
+				name := args[0]
+				if err := validateContextName(name); err != nil {
+					return err
+				}
+				fqns := switcher.Current(ctx)
+				if len(args) == 2 {
+					opts := switcher.Options{}
+					if err := ignoreOptions(cmd, &opts); err != nil {
+						return err
+					}
+					opts.Force, _ = cmd.Flags().GetBool("force")
+					if err := patternOptions(cmd, &opts); err != nil {
+						return err
+					}
+					fqnss, err := switcher.Resolve(ctx, args[1], opts)
+					if err != nil {
+						return err
+					}
+					fqns = fqnss[0]
+					if len(fqnss) > 1 {
+						candidates := make([]string, len(fqnss))
+						for i, fqns := range fqnss {
+							candidates[i] = formatFQNS(fqns)
+						}
+						selection, err := a.prompt("context:", candidates, candidates[0], true, "")
+						if err != nil {
+							return err
+						}
+						fqns = fqnss[index(candidates, selection)]
+					}
+				}
+				if _, ok := ctx.Contexts()[name]; ok {
+					if _, ok := saved[name]; !ok {
+						if overwrite, _ := cmd.Flags().GetBool("overwrite"); !overwrite {
+							return nsx.Errorf(nsx.CodeUsage,
+								`"%s" context already exists (--overwrite is required to replace context not created by kubensx)`, name)
+						}
+					}
+				}
+				if ctx.Save(name, fqns) {
+					fmt.Printf("+ %s (%s)\n", name, formatFQNS(fqns))
+				}
+			}
+			if !dryRun {
+				if err := ctx.Commit(); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Example: "  # save current user:cluster/namespace as \"dev\" context\n" +
+			"  kubensx save dev\n" +
+			"  # save alice:us-west1/staging as \"staging\" context\n" +
+			"  kubensx save staging alice:west/staging\n" +
+			"  \n" +
+			"  # list contexts created with kubensx save/materialize\n" +
+			"  kubensx save -l\n" +
+			"  \n" +
+			"  # delete \"dev\" context\n" +
+			"  kubensx save -d dev\n" +
+			"  # delete all contexts created with kubensx save/materialize\n" +
+			"  kubensx save --delete-all",
+	}
+	saveCmd.Flags().Bool("case-sensitive", false, caseSensitiveFlagUsage)
+	saveCmd.Flags().BoolP("delete", "d", false, "Delete context(s) (created with kubensx save/materialize)")
+	saveCmd.Flags().Bool("delete-all", false, "Delete all contexts created with kubensx save/materialize")
+	saveCmd.Flags().BoolP("dry-run", "x", false, "Do not modify the config (just show what's going happen)")
+	saveCmd.Flags().BoolP("exact", "e", false, "Match exactly (instead of default (wildcard) matching)")
+	saveCmd.Flags().BoolP("force", "f", false, "Skip namespace validation (NOTE: namespace must be provided --exact|ly)")
+	saveCmd.Flags().BoolP("fuzzy", "z", false, "Match fuzzily (instead of default (wildcard) matching)")
+	saveCmd.Flags().Bool("ignore-assoc", false, "Ignore user:cluster assoc[iations] (if any)")
+	saveCmd.Flags().Bool("ignore-ns-list", false, "Ignore explicit user:cluster/namespace(s) (if any)")
+	saveCmd.Flags().BoolP("list", "l", false, "List contexts created with kubensx save/materialize")
+	saveCmd.Flags().Bool("overwrite", false, "Replace context even if it wasn't created by kubensx")
+	saveCmd.Flags().BoolP("regex", "r", false, regexFlagUsage)
+	rootCmd.AddCommand(saveCmd)
+	tagCmd := &cobra.Command{
+		Use:     "tag [cluster-pattern] [tag...]",
+		Aliases: []string{"t"},
+		Short:   "Tag cluster(s) (e.g. prod, staging, dev)",
+		Long: "Tag cluster(s) (e.g. prod, staging, dev)\n\n" +
+			"Tagged clusters can be referenced with #<tag> in place of <cluster> (e.g. \"kubensx use '#prod/default'\").",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, err := a.newContext()
+			if err != nil {
+				return err
+			}
+			untag, _ := cmd.Flags().GetBool("delete")
+			if untag && len(args) == 0 {
+				return flagErrorf("pattern (<cluster>) required")
+			}
+			untagAll, _ := cmd.Flags().GetBool("delete-all")
+			if untagAll && len(args) != 0 {
+				return flagErrorf("--delete-all and pattern cannot be used together")
+			}
+			tagsByCluster := ctx.Tags()
+			if list, _ := cmd.Flags().GetBool("list"); list {
+				if untag || untagAll {
+					return flagErrorf("--list and --delete/--delete-all cannot be used together")
+				}
+				var clusters []string
+				for cluster := range tagsByCluster {
+					clusters = append(clusters, cluster)
+				}
+				for _, cluster := range sortInPlace(clusters) {
+					fmt.Printf("%s %s\n", cluster, formatTags(tagsByCluster[cluster]))
+				}
+				return nil
+			}
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			if len(args) == 0 && !untagAll {
+				if dryRun {
+					return pflag.ErrHelp
+				}
+				if err := requireClusters(ctx); err != nil {
+					return err
+				}
+				cluster, err := a.promptLabeled("cluster:", sortInPlace(ctx.Clusters()), ctx.Cluster(), true,
+					clusterLabel(ctx), clusterPreview)
+				if err != nil {
+					return err
+				}
+				tags := sortInPlace(tagsByCluster[cluster])
+				input, err := a.ui.Input("tag(s):", strings.Join(tags, " "), "space-separated")
+				if err != nil {
+					return err
+				}
+				var utags []string
+				for _, m := range whitespace.Split(input, -1) {
+					if m != "" {
+						if err := validateTag(m); err != nil {
+							return err
+						}
+						utags = append(utags, strings.TrimPrefix(m, "#"))
+					}
+				}
+				for _, tag := range tags {
+					if index(utags, tag) == -1 && ctx.Untag(cluster, tag) {
+						fmt.Printf("- %s #%s\n", cluster, tag)
+					}
+				}
+				for _, tag := range utags {
+					if ctx.Tag(cluster, tag) {
+						fmt.Printf("+ %s #%s\n", cluster, tag)
+					}
+				}
+			} else {
+				clusterMatcher := match.All
+				var tags []string
+				if len(args) != 0 {
+					pattern, patternMatcher, err := newPatternMatcher(cmd, args[0])
+					if err != nil {
+						return err
+					}
+					if pattern == "" {
+						return nsx.Errorf(nsx.CodeUsage, "<cluster> cannot be empty")
+					}
+					clusterMatcher = switcher.BindCluster(ctx, patternMatcher, pattern, ctx.Cluster())
+					for _, tag := range args[1:] {
+						if err := validateTag(tag); err != nil {
+							return err
+						}
+						tags = append(tags, strings.TrimPrefix(tag, "#"))
+					}
+					if len(tags) == 0 && !untag {
+						return flagErrorf("at least one tag required")
+					}
+				}
+				for _, cluster := range sortInPlace(match.Values(clusterMatcher(ctx.Clusters()))) {
+					if untag || untagAll {
+						ctags := tags
+						if len(ctags) == 0 {
+							ctags = tagsByCluster[cluster]
+						}
+						for _, tag := range sortInPlace(ctags) {
+							if ctx.Untag(cluster, tag) {
+								fmt.Printf("- %s #%s\n", cluster, tag)
+							}
+						}
+					} else {
+						for _, tag := range tags {
+							if ctx.Tag(cluster, tag) {
+								fmt.Printf("+ %s #%s\n", cluster, tag)
+							}
+						}
+					}
+				}
+			}
+			if !dryRun {
+				if err := ctx.Commit(); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Example: "  # (interactive)\n" +
+			"  kubensx tag\n" +
+			"  # tag all clusters containing \"prod\" in their name as prod\n" +
+			"  kubensx tag 'prod*' prod\n" +
+			"  \n" +
+			"  # list tags\n" +
+			"  kubensx tag -l\n" +
+			"  \n" +
+			"  # remove staging tag from us-west1\n" +
+			"  kubensx tag -d us-west1 staging\n" +
+			"  # remove all tags from clusters tagged as dev\n" +
+			"  kubensx tag -d '#dev'",
+	}
+	tagCmd.Flags().Bool("case-sensitive", false, caseSensitiveFlagUsage)
+	tagCmd.Flags().BoolP("delete", "d", false, "Delete tag(s)")
+	tagCmd.Flags().Bool("delete-all", false, "Delete all tags")
+	tagCmd.Flags().BoolP("dry-run", "x", false, "Do not modify the config (just show what's going happen)")
+	tagCmd.Flags().BoolP("exact", "e", false, "Match exactly (instead of default (wildcard) matching)")
+	tagCmd.Flags().BoolP("fuzzy", "z", false, "Match fuzzily (instead of default (wildcard) matching)")
+	tagCmd.Flags().BoolP("regex", "r", false, regexFlagUsage)
+	tagCmd.Flags().BoolP("list", "l", false, "List tags")
+	rootCmd.AddCommand(tagCmd)
+	undoCmd := &cobra.Command{
+		Use:   "undo [backup]",
+		Short: "Restore kubeconfig from the latest backup (or the given one)",
+		Long: "Restore kubeconfig from the latest backup (or the given one (see \"kubensx backups ls\"))\n\n" +
+			"Changes are shown (as unified diff) and confirmed before kubeconfig is overwritten.\n" +
+			"kubeconfig is backed up before it's restored, so undo is single-level: a second undo reverts the first one.\n" +
+			"Use \"kubensx undo <backup>\" to go further back.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 1 {
+				return pflag.ErrHelp
+			}
+			var candidates []backup.Backup
+			if len(args) == 1 {
+				b, ok, err := backup.Find(args[0])
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return nsx.Errorf(nsx.CodeNoMatch, `"%s" is not one of the backups (see "kubensx backups ls")`,
+						args[0])
+				}
+				candidates = []backup.Backup{b}
+			} else {
+				var err error
+				if candidates, err = backup.List(); err != nil {
+					return err
+				}
+				if len(candidates) == 0 {
+					return nsx.Errorf(nsx.CodeNoMatch, "No backups have been found (in %s).", backup.Dir)
+				}
+			}
+			// the latest backup that differs from kubeconfig
+			var b backup.Backup
+			var d string
+			for _, candidate := range candidates {
+				var err error
+				if d, err = backupDiff(candidate); err != nil {
+					return err
+				}
+				if d != "" {
+					b = candidate
+					break
+				}
+			}
+			if d == "" {
+				fmt.Println("Nothing to undo (kubeconfig is identical to " + candidates[0].ID + ")")
+				return nil
+			}
+			printDiff(d)
+			if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+				return nil
+			}
+			if yes, _ := cmd.Flags().GetBool("yes"); !yes {
+				answer, err := a.ui.Select("restore "+b.ID+"?", []string{"no", "yes"}, "no", "")
+				if err != nil {
+					return err
+				}
+				if answer != "yes" {
+					return nsx.Errorf(nsx.CodeAborted, "Aborted")
+				}
+			}
+			if _, _, err := backup.Create(b.Paths()); err != nil {
+				return err
+			}
+			if err := backup.Restore(b); err != nil {
+				return err
+			}
+			fmt.Printf("Restored %s from %s\n", strings.Join(b.Paths(), ", "), b.ID)
+			return nil
+		},
+		Example: "  kubensx undo\n" +
+			"  # show what's going to be restored\n" +
+			"  kubensx undo --dry-run\n" +
+			"  kubensx undo 20180501T140000.000000000",
+	}
+	undoCmd.Flags().BoolP("dry-run", "x", false, "Do not modify the config (just show what's going happen)")
+	undoCmd.Flags().BoolP("yes", "y", false, "Do not ask for confirmation")
+	rootCmd.AddCommand(undoCmd)
+	useCmd := &cobra.Command{
+		Use:     "use [user:cluster/namespace|@context]",
+		Aliases: []string{"u"},
+		Short:   "Change context",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if fromDir, _ := cmd.Flags().GetBool("from-dir"); fromDir {
+				if len(args) != 0 {
+					return flagErrorf("--from-dir cannot be combined with user:cluster/namespace|@context")
+				}
+				pattern, path, err := pinned()
+				if err != nil {
+					return err
+				}
+				log.Debugf(`Using "%s" (%s)`, pattern, path)
+				args = []string{pattern}
+			}
+			ctxOpts := a.context
+			var err error
+			if ctxOpts.InPlace, err = flagOrEnv(cmd, "in-place", "KUBENSX_IN_PLACE"); err != nil {
+				return err
+			}
+			ctx, err := a.openContext(ctxOpts, commandLine())
+			if err != nil {
+				return err
+			}
+			warnIfDrifted(ctx)
+			u, _ := cmd.Flags().GetBool("user")
+			c, _ := cmd.Flags().GetBool("cluster")
+			n, _ := cmd.Flags().GetBool("namespace")
+			if !n {
+				n, _ = cmd.Flags().GetBool("ns")
+			}
+			named, _ := cmd.Flags().GetBool("context")
+			if named && (u || c || n) {
+				return flagErrorf("--context and --user(-u)/--cluster(-c)/--namespace(--ns,-n) cannot be used together")
+			}
+			// named contexts are offered first unless -u/-c/-n is given
+			offerNamed := !u && !c && !n
+			if !u && !c && !n {
+				u, c, n = true, true, true
+			}
+			prev := switcher.Current(ctx)
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			if dryRun {
+				ctx = nsx.DryRun(ctx)
+			}
+			showDiff, _ := cmd.Flags().GetBool("diff")
+			if ctx, err = withDiff(cmd, ctx); err != nil {
+				return err
+			}
+			ttl, _ := cmd.Flags().GetDuration("for")
+			if ttl < 0 {
+				return flagErrorf("--for cannot be negative")
+			}
+			noAutoSelect, _ := cmd.Flags().GetBool("no-auto-select")
+			rank := loadFrecency()
+			opts := switcher.Options{Ranker: rank}
+			if err := ignoreOptions(cmd, &opts); err != nil {
+				return err
+			}
+			opts.Force, _ = cmd.Flags().GetBool("force")
+			promptPattern := func(step switcher.Step, def string, label func(string) string,
+				score func(string) float64, preview string) (string, error) {
+				if err := step.Err(); err != nil {
+					return "", err
+				}
+				results := step.Matches
+				matches := match.Values(results)
+				if len(matches) == 1 {
+					return matches[0], nil
+				}
+				if !noAutoSelect && dominates(matches, score) {
+					log.Debugf(`Selected "%s" (frecency %v)`, matches[0], score(matches[0]))
+					return matches[0], nil
+				}
+				resultByValue := make(map[string]match.Result, len(results))
+				for _, r := range results {
+					resultByValue[r.Value] = r
+				}
+				// best-scored match goes first (def is kept selected unless it's outscored)
+				opt := results[0]
+				if r, ok := resultByValue[def]; ok && score(def) == score(opt.Value) && r.Score == opt.Score {
+					opt = r
+				}
+				selection, err := a.promptLabeled(step.Kind+":", matches, opt.Value, true, func(value string) string {
+					l := label(value)
+					if r, ok := resultByValue[value]; ok && strings.HasPrefix(l, value) {
+						return highlight(r) + l[len(value):]
+					}
+					return l
+				}, preview)
+				if err != nil {
+					return "", err
+				}
+				a.ui.EraseAnswer()
+				return selection, nil
+			}
+			contexts := ctx.Contexts()
+			contextRank := func(name string) float64 {
+				return rank.Namespace(contexts[name].Cluster, contexts[name].NS)
+			}
+			var next nsx.FQNS
+			// named context selected on the first page of the interactive flow (if any)
+			var name string
+			if len(args) == 0 && (named || offerNamed && len(contexts) != 0) {
+				if err := requireContexts(ctx); err != nil {
+					return err
+				}
+				names := rankInPlace(keys(contexts), contextRank)
+				def := contextNameOf(contexts, prev)
+				if !named {
+					// "" stands for "select user, cluster & namespace instead"
+					names = append(names, "")
+				}
+				if def == "" && named {
+					def = names[0]
+				}
+				label := contextLabel(contexts)
+				name, err = a.promptLabeled("context:", names, def, true, func(name string) string {
+					if name == "" {
+						return "(other)"
+					}
+					return label(name)
+				}, "")
+				if err != nil {
+					return err
+				}
+			}
+			if name != "" {
+				next = contexts[name]
+			} else if len(args) == 0 {
+				if err := requireClusters(ctx); err != nil {
+					return err
+				}
+				if err := requireUsers(ctx); err != nil {
+					return err
+				}
+				cluster, err := a.promptLabeled("cluster:", rankInPlace(ctx.Clusters(), rank.Cluster), ctx.Cluster(), c,
+					clusterLabel(ctx), clusterPreview)
+				if err != nil {
+					return err
+				}
+				users := rankInPlace(switcher.Users(ctx, cluster, opts.IgnoreAssoc), rank.userRank(cluster))
+				user := ctx.User()
+				if index(users, user) == -1 {
+					user = users[0]
+				}
+				if user, err = a.prompt("user:", users, user, u, ""); err != nil {
+					return err
+				}
+				nss, err := switcher.Namespaces(ctx, user, cluster, !opts.IgnoreNSList)
+				if err != nil {
+					return err
+				}
+				var ns string
+				if len(nss) == 0 {
+					fmt.Print("\nIt appears that the user you have selected is not allowed to list namespaces.\n" +
+						"If you wish to avoid manual entry next time you `kubensx use` - see `kubensx ns-list --help`.\n\n")
+					if ns, err = a.ui.Input("namespace:", "", ""); err != nil {
+						return err
+					}
+					if err := validateNS(ns); err != nil {
+						return err
+					}
+				} else {
+					ns, err = a.prompt("namespace:", rankInPlace(nss, rank.namespaceRank(cluster)), ctx.Namespace(), n,
+						namespacePreview(user, cluster))
+					if err != nil {
+						return err
+					}
+				}
+				next = nsx.FQNS{User: user, Cluster: cluster, NS: ns}
+			} else if args[0] == "-" {
+				next = switcher.Previous(ctx)
+			} else if named || strings.HasPrefix(args[0], "@") {
+				if err := patternOptions(cmd, &opts); err != nil {
+					return err
+				}
+				if err := requireContexts(ctx); err != nil {
+					return err
+				}
+				step, err := switcher.Contexts(ctx, strings.TrimPrefix(args[0], "@"), opts)
+				if err != nil {
+					return err
+				}
+				if dryRun {
+					if err := step.Err(); err != nil {
+						return err
+					}
+					label := contextLabel(contexts)
+					for _, m := range step.Matches {
+						fmt.Println(highlight(m) + strings.TrimPrefix(label(m.Value), m.Value))
+					}
+					return nil
+				}
+				name, err := promptPattern(step, contextNameOf(contexts, prev), contextLabel(contexts), contextRank, "")
+				if err != nil {
+					return err
+				}
+				next = contexts[name]
+			} else {
+				if !(u && c && n) {
+					if u && c || u && n || c && n {
+						return flagErrorf("--user(-u)/--cluster(-c)/--namespace(--ns,-n) cannot be used together")
+					}
+					switch {
+					case u:
+						opts.Scope = switcher.ScopeUser
+					case c:
+						opts.Scope = switcher.ScopeCluster
+					case n:
+						opts.Scope = switcher.ScopeNamespace
+					}
+				}
+				if err := patternOptions(cmd, &opts); err != nil {
+					return err
+				}
+				r, err := switcher.NewResolver(ctx, args[0], opts)
+				if err != nil {
+					return err
+				}
+				p := r.Pattern
+				log.Debugf(`Searching for "%s(%v):%s/%s(%v)"`, p.User, p.UserExplicit, p.Cluster, p.NS, p.NSExplicit)
+				if dryRun {
+					matches, err := r.Resolve()
+					if err != nil {
+						return err
+					}
+					for _, m := range matches {
+						fmt.Printf("%s:%s/%s\n", highlight(m.User), highlight(m.Cluster), highlight(m.NS))
+					}
+					return nil
+				}
+				if err := requireClusters(ctx); err != nil {
+					return err
+				}
+				if err := requireUsers(ctx); err != nil {
+					return err
+				}
+				if next.Cluster, err = promptPattern(r.Clusters(), prev.Cluster, clusterLabel(ctx), rank.Cluster,
+					clusterPreview); err != nil {
+					return err
+				}
+				if next.User, err = promptPattern(r.Users(next.Cluster), prev.User, noLabel, rank.userRank(next.Cluster),
+					""); err != nil {
+					return err
+				}
+				step, err := r.Namespaces(next.User, next.Cluster)
+				if err != nil {
+					return err
+				}
+				if next.NS, err = promptPattern(step, prev.NS, noLabel, rank.namespaceRank(next.Cluster),
+					namespacePreview(next.User, next.Cluster)); err != nil {
+					return err
+				}
+			}
+			if !dryRun && !showDiff {
+				yes, _ := cmd.Flags().GetBool("yes")
+				if err := a.confirmProtected(ctx, prev, next, yes); err != nil {
+					return err
+				}
+			}
+			if ttl > 0 {
+				revertTo, _, ok := ctx.Expiry()
+				if !ok {
+					revertTo = prev
+				}
+				ctx.SetExpiry(revertTo, time.Now().Add(ttl))
+			} else {
+				// explicit switch takes precedence over the pending one
+				ctx.DeleteExpiry()
+			}
+			// with --dry-run or --diff, ctx does not write changes
+			if err := switcher.Switch(ctx, next); err != nil {
+				return err
+			}
+			if showDiff {
+				return nil
+			}
+			if ttl > 0 {
+				fmt.Printf("Switched to %s (for %s)\n", formatFQNS(next), ttl)
+				return nil
+			}
+			fmt.Println("Switched to " + formatFQNS(next))
+			return nil
+		},
+	}
+	useCmd.Flags().Bool("case-sensitive", false, caseSensitiveFlagUsage)
+	useCmd.Flags().BoolP("cluster", "c", false, "Change cluster only")
+	useCmd.Flags().Bool("context", false, "Switch to one of the named contexts (e.g. created by gcloud/aws eks/az aks)"+
+		"\n(pattern is matched against context names; alternatively, pattern can be prefixed with @)")
+	useCmd.Flags().Bool("diff", false, diffFlagUsage)
+	useCmd.Flags().BoolP("dry-run", "x", false, "List matches (without changing the context)")
 	useCmd.Flags().BoolP("exact", "e", false, "Match exactly (by default wildcard matching is used)")
+	useCmd.Flags().Duration("for", 0, "Switch back to the current context after specified amount of time (e.g. 15m)"+
+		"\n(the switch happens on the first command that reads or changes current context after the deadline)")
+	useCmd.Flags().Bool("from-dir", false, "Switch to user:cluster/namespace (or @context) pinned by .kubensx "+
+		"in the current directory (or any of its parents)\n(see \"kubensx hook --help\")")
 	useCmd.Flags().BoolP("fuzzy", "z", false, "Match fuzzily (by default wildcard matching is used)")
+	useCmd.Flags().BoolP("regex", "r", false,
+		"Match using regular expression(s) (by default wildcard matching is used)"+regexFlagNote)
 	useCmd.Flags().Bool("ignore-assoc", false, "Ignore user:cluster assoc[iations] (if any)")
+	useCmd.Flags().Bool("in-place", false, "Change namespace of the named current context (e.g. gke_project_us-west1_main) "+
+		"in place\n(by default, named context is copied to kubensx-current first; KUBENSX_IN_PLACE=true to change the default)")
 	useCmd.Flags().Bool("ignore-ns-list", false, "Ignore explicit user:cluster/namespace(s) (if any)")
 	useCmd.Flags().BoolP("namespace", "n", false, "Change namespace only")
+	useCmd.Flags().Bool("no-auto-select", false, "Always ask to select when there are two or more matches"+
+		"\n(by default, the match used (much) more frequently/recently than the rest is selected automatically)")
 	useCmd.Flags().Bool("ns", false, "Alias for --namespace")
 	useCmd.Flags().BoolP("user", "u", false, "Change user only")
+	useCmd.Flags().BoolP("yes", "y", false, "Do not ask for confirmation when switching to a protected cluster/namespace"+
+		"\n(see \"kubensx protect --help\")")
 	useCmd.Flags().BoolP("force", "f", false, "Skip namespace validation (NOTE: namespace must be provided --exact|ly)"+
 		"\n(useful when user is not allowed to list namespaces; see also \"kubensx ns-list --help\")")
 	rootCmd.AddCommand(useCmd)
@@ -734,16 +1972,39 @@ func main() {
 	rootCmd.PersistentFlags().Bool("debug", false, "Turn on debug output")
 	rootCmd.PersistentFlags().String("kubeconfig", "", "Path to the config file (e.g. ~/.kube/config)")
 	rootCmd.PersistentFlags().Bool("no-color", false, "Disable color output")
+	rootCmd.PersistentFlags().Bool("no-input", false, "Fail (with exit code 4) instead of prompting for input"+
+		"\n(on by default when stdin is not a terminal)")
 	rootCmd.Flags().Bool("version", false, "Print version information")
-	if err := rootCmd.Execute(); err != nil {
-		log.Debug(err)
-		os.Exit(-1)
-	}
+	return rootCmd
+}
+
+// flagError is printed along with usage.
+type flagError struct {
+	error
+}
+
+func flagErrorf(format string, a ...interface{}) error {
+	return flagError{fmt.Errorf(format, a...)}
 }
 
 func validateNS(ns string) error {
 	if !validNS.MatchString(ns) {
-		return fmt.Errorf(`"%s" is not a valid namespace`, ns)
+		return nsx.Errorf(nsx.CodeUsage, `"%s" is not a valid namespace`, ns)
+	}
+	return nil
+}
+
+func validateContextName(name string) error {
+	// kubensx-* contexts are reserved for kubensx's own use (e.g. kubensx-current)
+	if strings.TrimSpace(name) == "" || strings.HasPrefix(name, "kubensx-") {
+		return nsx.Errorf(nsx.CodeUsage, `"%s" is not a valid context name`, name)
+	}
+	return nil
+}
+
+func validateTag(tag string) error {
+	if !validTag.MatchString(tag) {
+		return nsx.Errorf(nsx.CodeUsage, `"%s" is not a valid tag`, tag)
 	}
 	return nil
 }
@@ -773,198 +2034,776 @@ func sortFQNSSliceInPlace(s []nsx.FQNS) []nsx.FQNS {
 	return s
 }
 
-func mustContainAtLeastOneCluster(ctx nsx.Context) {
+func readFileOrStdin(file string) ([]byte, error) {
+	if file == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(file)
+}
+
+func requireClusters(ctx nsx.Context) error {
 	if len(ctx.Clusters()) == 0 {
-		log.Fatal("No clusters have been found.\n" +
+		return nsx.Errorf(nsx.CodeNoMatch, "No clusters have been found.\n"+
 			"See `kubectl config set-cluster --help` on how to add one.")
 	}
+	return nil
+}
+
+func requireContexts(ctx nsx.Context) error {
+	if len(ctx.Contexts()) == 0 {
+		return nsx.Errorf(nsx.CodeNoMatch, "No contexts have been found.\n"+
+			"See `kubectl config set-context --help` on how to add one.")
+	}
+	return nil
 }
 
-func mustContainAtLeastOneUser(ctx nsx.Context) {
+func requireUsers(ctx nsx.Context) error {
 	if len(ctx.Users()) == 0 {
-		log.Fatal("No users have been found.\n" +
+		return nsx.Errorf(nsx.CodeNoMatch, "No users have been found.\n"+
 			"See `kubectl config set-credentials --help` on how to add one.")
 	}
+	return nil
 }
 
-func requireNamespaces(ctx nsx.Context, explicit bool) []string {
-	var r []string
-	var err error
-	if explicit {
-		r, err = ctx.NamespaceView()
+func formatContext(ctx nsx.Context) string {
+	return fmt.Sprintf("%s:%s/%s", ctx.User(), ctx.Cluster(), ctx.Namespace())
+}
+
+const caseSensitiveFlagUsage = "Match case-sensitively" +
+	"\n(by default, matching is case-insensitive unless pattern contains uppercase characters (smart-case);" +
+	"\nKUBENSX_CASE=smart|sensitive|insensitive to change the default)"
+
+const regexFlagUsage = "Match using regular expression(s) (instead of default (wildcard) matching)" + regexFlagNote
+
+// regexFlagNote follows the usage of --regex.
+const regexFlagNote = "\n(alternatively, pattern can be wrapped in /.../)" +
+	"\n\":\" and \"/\" always separate segments, so an expression cannot contain them (e.g. (?:a|b))"
+
+const diffFlagUsage = "Show changes to the kubeconfig as unified diff (instead of making them)" +
+	"\n(entries kubensx would clean up (e.g. assoc[iations] of users that no longer exist) included)"
+
+// diffOnCommit prints unified diff of the kubeconfig on Commit instead of writing it.
+type diffOnCommit struct {
+	nsx.Context
+}
+
+func (ctx diffOnCommit) Commit() error {
+	before, after, err := ctx.Diff()
+	if err != nil {
+		return err
+	}
+	ctx.Rollback()
+	printDiff(diff.Unified(before, after, "kubeconfig", "kubeconfig"))
+	return nil
+}
+
+// backupDiff returns unified diff between kubeconfig files and their copies in b.
+func backupDiff(b backup.Backup) (string, error) {
+	var r string
+	for _, path := range b.Paths() {
+		curr, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		prev, err := ioutil.ReadFile(b.Files[path])
+		if err != nil {
+			return "", err
+		}
+		r += diff.Unified(string(curr), string(prev), path, path+" ("+b.ID+")")
+	}
+	return r, nil
+}
+
+func printDiff(d string) {
+	for _, line := range strings.SplitAfter(d, "\n") {
+		switch {
+		case strings.HasPrefix(line, "---"), strings.HasPrefix(line, "+++"):
+			fmt.Print(color.New(color.Bold).Sprint(line))
+		case strings.HasPrefix(line, "@@"):
+			fmt.Print(color.CyanString(line))
+		case strings.HasPrefix(line, "-"):
+			fmt.Print(color.RedString(line))
+		case strings.HasPrefix(line, "+"):
+			fmt.Print(color.GreenString(line))
+		default:
+			fmt.Print(line)
+		}
+	}
+}
+
+// withDiff wraps ctx into diffOnCommit if --diff is set.
+func withDiff(cmd *cobra.Command, ctx nsx.Context) (nsx.Context, error) {
+	if showDiff, _ := cmd.Flags().GetBool("diff"); !showDiff {
+		return ctx, nil
+	}
+	if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+		return nil, flagErrorf("--diff cannot be combined with --dry-run(-x)")
+	}
+	return diffOnCommit{ctx}, nil
+}
+
+func printVerbose(ctx nsx.Context) {
+	faint := color.New(color.Faint).SprintFunc()
+	fmt.Println(formatContext(ctx))
+	fmt.Println(faint("context: ") + ctx.CurrentContext())
+	fmt.Println(faint("previous: ") + fmt.Sprintf("%s:%s/%s",
+		ctx.UserPrevious(), ctx.ClusterPrevious(), ctx.NamespacePrevious()))
+	if revertTo, deadline, ok := ctx.Expiry(); ok {
+		fmt.Println(faint("expires: ") + fmt.Sprintf("%s (then %s)",
+			deadline.Local().Format("15:04:05"), formatFQNS(revertTo)))
+	}
+	if last, lastContext, ok := ctx.Drift(); ok {
+		fmt.Println(faint("drift: ") + color.YellowString("current-context was changed outside of kubensx") +
+			fmt.Sprintf(" (kubensx left %s (%s) current)", lastContext, formatFQNS(last)))
 	} else {
-		r, err = ctx.Namespaces()
+		fmt.Println(faint("drift: ") + "none")
 	}
+}
+
+// pinEnv holds path to the .kubensx the overlay was switched for.
+// originalKubeconfigEnv holds KUBECONFIG as it was before the overlay was prepended to it.
+// sessionEnv holds path to the overlay created by "kubensx init". The audit log uses it as the session id.
+const pinEnv, originalKubeconfigEnv, sessionEnv = "KUBENSX_PIN", "KUBENSX_ORIGINAL_KUBECONFIG", "KUBENSX_SESSION"
+
+// pinned returns pattern from the closest .kubensx along with the path to the file.
+func pinned() (pattern string, path string, err error) {
+	cwd, err := os.Getwd()
 	if err != nil {
-		log.Fatal(err)
+		return "", "", err
 	}
-	return r
+	if path, err = pin.Find(cwd); err != nil {
+		return "", "", err
+	}
+	if path == "" {
+		return "", "", nsx.Errorf(nsx.CodeNoMatch, `No %s found in "%s" (or any of its parents)`, pin.FileName, cwd)
+	}
+	if pattern, err = pin.Read(path); err != nil {
+		return "", "", nsx.NewError(nsx.CodeUsage, err)
+	}
+	return pattern, path, nil
 }
 
-func formatContext(ctx nsx.Context) string {
-	return fmt.Sprintf("%s:%s/%s", ctx.User(), ctx.Cluster(), ctx.Namespace())
+// resolvePinned resolves pattern that must match exactly one context.
+func resolvePinned(ctx nsx.Context, pattern string) (nsx.FQNS, error) {
+	c, err := caseMode()
+	if err != nil {
+		return nsx.FQNS{}, err
+	}
+	opts := switcher.Options{Case: c}
+	if strings.HasPrefix(pattern, "@") {
+		step, err := switcher.Contexts(ctx, strings.TrimPrefix(pattern, "@"), opts)
+		if err != nil {
+			return nsx.FQNS{}, err
+		}
+		if err := step.Err(); err != nil {
+			return nsx.FQNS{}, err
+		}
+		if matches := match.Values(step.Matches); len(matches) != 1 {
+			return nsx.FQNS{}, nsx.Errorf(nsx.CodeInputRequired, `"%s" is ambiguous. Candidates:\n%s`,
+				pattern, strings.Join(matches, "\n"))
+		}
+		return ctx.Contexts()[step.Matches[0].Value], nil
+	}
+	matches, err := switcher.Resolve(ctx, pattern, opts)
+	if err != nil {
+		return nsx.FQNS{}, err
+	}
+	if len(matches) != 1 {
+		candidates := make([]string, len(matches))
+		for i, m := range matches {
+			candidates[i] = formatFQNS(m)
+		}
+		return nsx.FQNS{}, nsx.Errorf(nsx.CodeInputRequired, `"%s" is ambiguous. Candidates:\n%s`,
+			pattern, strings.Join(candidates, "\n"))
+	}
+	return matches[0], nil
 }
 
-type partialMatcher = func(pattern string, arr []string) []string
-type matcher = func(arr []string) []string
+// shellEnv accumulates environment changes for the shell to evaluate.
+type shellEnv struct {
+	shell string
+	bytes.Buffer
+}
+
+func newShellEnv(shell string) (*shellEnv, error) {
+	if shell != "bash" && shell != "zsh" && shell != "fish" {
+		return nil, flagErrorf(`unsupported shell "%s" (expected bash, zsh or fish)`, shell)
+	}
+	return &shellEnv{shell: shell}, nil
+}
 
-func bindMatcher(m partialMatcher, pattern string, def string) matcher {
-	switch pattern {
-	case ".":
-		return func(arr []string) []string { return []string{def} }
-	case "*":
-		return matchAll
-	default:
-		return func(arr []string) []string {
-			return m(pattern, arr)
+func (env *shellEnv) set(key string, value string) {
+	if env.shell == "fish" {
+		fmt.Fprintf(env, "set -gx %s %s;\n", key, selector.Quote(value))
+	} else {
+		fmt.Fprintf(env, "export %s=%s;\n", key, selector.Quote(value))
+	}
+}
+
+func (env *shellEnv) unset(key string) {
+	if env.shell == "fish" {
+		fmt.Fprintf(env, "set -e %s;\n", key)
+	} else {
+		fmt.Fprintf(env, "unset %s;\n", key)
+	}
+}
+
+func originalKubeconfig() string {
+	if os.Getenv(nsxkubectl.OverlayEnv) != "" {
+		return os.Getenv(originalKubeconfigEnv)
+	}
+	return os.Getenv("KUBECONFIG")
+}
+
+// enterOverlay creates an overlay and makes it effective for both the rest of the command and the shell.
+func enterOverlay(env *shellEnv) (string, error) {
+	original := originalKubeconfig()
+	overlay, err := nsxkubectl.NewOverlay()
+	if err != nil {
+		return "", err
+	}
+	kubeconfig := nsxkubectl.WithOverlay(overlay, original)
+	os.Setenv("KUBECONFIG", kubeconfig)
+	os.Setenv(nsxkubectl.OverlayEnv, overlay)
+	env.set("KUBECONFIG", kubeconfig)
+	env.set(nsxkubectl.OverlayEnv, overlay)
+	env.set(originalKubeconfigEnv, original)
+	return overlay, nil
+}
+
+// initEnv writes statements that put shell into a new session (see "kubensx init --help").
+func initEnv(w io.Writer, shell string) error {
+	env, err := newShellEnv(shell)
+	if err != nil {
+		return err
+	}
+	overlay, err := enterOverlay(env)
+	if err != nil {
+		return err
+	}
+	env.set(sessionEnv, overlay)
+	env.unset(pinEnv)
+	_, err = w.Write(env.Bytes())
+	return err
+}
+
+// hookEnv writes statements that switch shell to whatever the closest .kubensx pins.
+// Shell is taken out of the overlay when there is no .kubensx.
+// The overlay of "kubensx init" session is reused and kept.
+func (a *app) hookEnv(w io.Writer, shell string) (err error) {
+	env, err := newShellEnv(shell)
+	if err != nil {
+		return err
+	}
+	overlay := os.Getenv(nsxkubectl.OverlayEnv)
+	if overlay != "" {
+		if _, err := os.Stat(overlay); err != nil {
+			log.Debugf(`Discarded overlay "%s" (%s)`, overlay, err)
+			overlay = ""
 		}
 	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	path, err := pin.Find(cwd)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		if os.Getenv(pinEnv) == "" {
+			return nil
+		}
+		if session := os.Getenv(sessionEnv); session != "" && session == os.Getenv(nsxkubectl.OverlayEnv) {
+			env.unset(pinEnv)
+		} else {
+			if original := originalKubeconfig(); original != "" {
+				env.set("KUBECONFIG", original)
+			} else {
+				env.unset("KUBECONFIG")
+			}
+			env.unset(nsxkubectl.OverlayEnv)
+			env.unset(pinEnv)
+			env.unset(originalKubeconfigEnv)
+			if overlay != "" {
+				if err := os.Remove(overlay); err != nil {
+					log.Debug(err)
+				}
+			}
+		}
+		_, err := w.Write(env.Bytes())
+		return err
+	}
+	if overlay != "" && os.Getenv(pinEnv) == path {
+		return nil
+	}
+	pattern, err := pin.Read(path)
+	if err != nil {
+		return nsx.NewError(nsx.CodeUsage, err)
+	}
+	if overlay == "" {
+		if overlay, err = enterOverlay(env); err != nil {
+			return err
+		}
+		defer func(overlay string) {
+			if err != nil {
+				os.Remove(overlay)
+			}
+		}(overlay)
+	}
+	ctx, err := a.newContext()
+	if err != nil {
+		return err
+	}
+	next, err := resolvePinned(ctx, pattern)
+	if err != nil {
+		return err
+	}
+	// there is no one to confirm the switch since stdout is evaluated by the shell
+	if p := a.protection(ctx, switcher.Current(ctx), next); p != "" {
+		return nsx.Errorf(nsx.CodeInputRequired, `"%s" is protected (use "kubensx use --from-dir" to switch)`, p)
+	}
+	if err := switcher.Switch(ctx, next); err != nil {
+		return err
+	}
+	env.set(pinEnv, path)
+	if _, err := w.Write(env.Bytes()); err != nil {
+		return err
+	}
+	log.Infof("Switched to %s (%s)", formatFQNS(next), path)
+	return nil
+}
+
+// currentSource returns path to the .kubensx the overlay was switched for, or to the kubeconfig current-context
+// comes from.
+func currentSource() (string, error) {
+	if os.Getenv(nsxkubectl.OverlayEnv) != "" && os.Getenv(pinEnv) != "" {
+		return os.Getenv(pinEnv), nil
+	}
+	return nsxkubectl.Source()
+}
+
+func warnIfDrifted(ctx nsx.Context) {
+	if last, lastContext, ok := ctx.Drift(); ok {
+		log.Warnf("Current context was changed outside of kubensx (%s (%s) -> %s (%s))",
+			lastContext, formatFQNS(last), ctx.CurrentContext(), formatContext(ctx))
+	}
 }
 
-func fallbackToAllAvailable(m matcher) matcher {
-	return func(arr []string) []string {
-		r := m(arr)
-		if len(r) == 0 {
-			r = arr
+func formatFQNS(fqns nsx.FQNS) string {
+	return fmt.Sprintf("%s:%s/%s", fqns.User, fqns.Cluster, fqns.NS)
+}
+
+// parseSince accepts a duration relative to now, time of the day, date or RFC3339 timestamp.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			return time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, now.Location()), nil
+		}
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			return t, nil
 		}
-		return r
 	}
+	return time.Time{}, flagErrorf(`--since: "%s" is neither a duration (e.g. 1h) nor a time (e.g. 14:00, 2018-05-01)`, value)
 }
 
-func allowEmpty(m matcher) matcher {
-	return func(arr []string) []string {
-		return m(append([]string{""}, arr...))
+func filterByTags(ctx nsx.Context, clusters []string, tags []string) []string {
+	if len(tags) == 0 {
+		return clusters
+	}
+	tagsByCluster := ctx.Tags()
+	var r []string
+nextcluster:
+	for _, cluster := range clusters {
+		for _, tag := range tags {
+			if index(tagsByCluster[cluster], strings.TrimPrefix(tag, "#")) == -1 {
+				continue nextcluster
+			}
+		}
+		r = append(r, cluster)
 	}
+	return r
 }
 
-func stableMatcher(m matcher) matcher {
-	return func(arr []string) []string {
-		return sortInPlace(m(arr))
+func formatTags(tags []string) string {
+	r := make([]string, 0, len(tags))
+	for _, tag := range sortInPlace(tags) {
+		r = append(r, "#"+tag)
 	}
+	return strings.Join(r, " ")
 }
 
-func matchAll(arr []string) []string {
-	return arr
+func highlight(r match.Result) string {
+	bold := color.New(color.Bold)
+	return match.Highlight(r, func(s string) string { return bold.Sprint(s) })
 }
 
-func matchExact(v string, arr []string) []string {
-	for _, a := range arr {
-		if a == v {
-			return []string{a}
+func rankInPlace(arr []string, score func(string) float64) []string {
+	sort.Slice(arr, func(i, j int) bool {
+		si, sj := score(arr[i]), score(arr[j])
+		if si != sj {
+			return si > sj
 		}
+		return arr[i] < arr[j]
+	})
+	return arr
+}
+
+// dominates tells whether the first element of ranked arr scores at least 3 times higher than the runner-up.
+func dominates(ranked []string, score func(string) float64) bool {
+	if len(ranked) == 0 || score(ranked[0]) < 100 {
+		return false
+	}
+	return len(ranked) == 1 || score(ranked[0]) >= 3*score(ranked[1])
+}
+
+type frecency struct {
+	clusters   map[string]float64
+	users      map[string]float64 // cluster:user -> score
+	namespaces map[string]float64 // cluster/namespace -> score
+}
+
+// loadFrecency computes frecency (frequency + recency) scores from the audit log.
+func loadFrecency() *frecency {
+	records, err := audit.Read(time.Time{})
+	if err != nil {
+		log.Debug(err)
+	}
+	now := time.Now()
+	return &frecency{
+		clusters: audit.Frecency(records, now, func(fqns nsx.FQNS) string { return fqns.Cluster }),
+		users: audit.Frecency(records, now, func(fqns nsx.FQNS) string {
+			return fqns.Cluster + ":" + fqns.User
+		}),
+		namespaces: audit.Frecency(records, now, func(fqns nsx.FQNS) string {
+			return fqns.Cluster + "/" + fqns.NS
+		}),
+	}
+}
+
+func (f *frecency) Cluster(cluster string) float64 {
+	return f.clusters[cluster]
+}
+
+func (f *frecency) User(cluster string, user string) float64 {
+	return f.users[cluster+":"+user]
+}
+
+func (f *frecency) Namespace(cluster string, namespace string) float64 {
+	return f.namespaces[cluster+"/"+namespace]
+}
+
+func (f *frecency) userRank(cluster string) func(string) float64 {
+	return func(user string) float64 {
+		return f.User(cluster, user)
+	}
+}
+
+func (f *frecency) namespaceRank(cluster string) func(string) float64 {
+	return func(namespace string) float64 {
+		return f.Namespace(cluster, namespace)
+	}
+}
+
+func newPatternMatcher(cmd *cobra.Command, pattern string) (string, match.Matcher, error) {
+	var opts switcher.Options
+	if err := patternOptions(cmd, &opts); err != nil {
+		return "", nil, err
+	}
+	return switcher.NewMatcher(pattern, opts.Mode, opts.Case)
+}
+
+// patternOptions sets opts.Mode and opts.Case from flags, falling back to KUBENSX_MATCH and KUBENSX_CASE.
+func patternOptions(cmd *cobra.Command, opts *switcher.Options) error {
+	c, err := caseMode()
+	if err != nil {
+		return err
+	}
+	if caseSensitive, _ := cmd.Flags().GetBool("case-sensitive"); caseSensitive {
+		c = match.CaseSensitive
+	}
+	opts.Case = c
+	if opts.Mode, err = matchMode(); err != nil {
+		return err
+	}
+	if exact, _ := cmd.Flags().GetBool("exact"); exact {
+		opts.Mode = switcher.Exact
+	} else if fuzzy, _ := cmd.Flags().GetBool("fuzzy"); fuzzy {
+		opts.Mode = switcher.Fuzzy
+	} else if regex, _ := cmd.Flags().GetBool("regex"); regex {
+		opts.Mode = switcher.Regex
 	}
 	return nil
 }
 
-func matchWildcard(v string, arr []string) []string {
-	var r []string
-	split := strings.Split(v, "*")
-nextstr:
-	for _, str := range arr {
-		if str == v {
-			r = []string{v}
-			break
+func caseMode() (match.Case, error) {
+	value := os.Getenv("KUBENSX_CASE")
+	if value == "" {
+		return match.SmartCase, nil
+	}
+	c, err := match.ParseCase(value)
+	if err != nil {
+		return c, nsx.Errorf(nsx.CodeUsage, "KUBENSX_CASE: %s", err.Error())
+	}
+	return c, nil
+}
+
+func matchMode() (switcher.Mode, error) {
+	value := os.Getenv("KUBENSX_MATCH")
+	if value == "" {
+		return switcher.Wildcard, nil
+	}
+	m, err := switcher.ParseMode(value)
+	if err != nil {
+		return m, nsx.Errorf(nsx.CodeUsage, "KUBENSX_MATCH: %s", err.Error())
+	}
+	return m, nil
+}
+
+// applySettings exports config file values that are not set in the environment and fills in app options.
+func (a *app) applySettings() {
+	values, err := settings.Load()
+	if err != nil {
+		log.Warn(err)
+	}
+	for _, err := range settings.Apply(values) {
+		log.Warn(err)
+	}
+	if value := os.Getenv("KUBENSX_HISTORY"); value != "" {
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			log.Warnf(`Ignored KUBENSX_HISTORY=%s (expected non-negative integer)`, value)
+		} else {
+			a.history = n
 		}
-		for _, sub := range split {
-			if sub != "" && !strings.Contains(str, sub) {
-				continue nextstr
-			}
+	}
+	// "kubensx use" reports invalid KUBENSX_IN_PLACE
+	if a.context.InPlace, err = inPlaceMode(); err != nil {
+		log.Debug(err)
+	}
+	a.context.NamespaceSelector = os.Getenv("KUBENSX_NAMESPACE_SELECTOR")
+	a.protected = parseProtected(os.Getenv("KUBENSX_PROTECTED"))
+}
+
+// protection is switcher.Protection that also takes KUBENSX_PROTECTED into account.
+func (a *app) protection(ctx nsx.Context, from nsx.FQNS, to nsx.FQNS) string {
+	return switcher.Protection(ctx, from, to, switcher.Options{Protected: a.protected})
+}
+
+// parseProtected parses comma-separated <cluster>[/<namespace>] entries.
+func parseProtected(value string) map[string][]string {
+	r := make(map[string][]string)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
 		}
-		r = append(r, str)
+		cluster, namespace := entry, ""
+		if i := strings.Index(entry, "/"); i != -1 {
+			cluster, namespace = entry[:i], entry[i+1:]
+		}
+		r[cluster] = append(r[cluster], namespace)
 	}
 	return r
 }
 
-func matchFuzzy(v string, arr []string) []string {
-	if index(arr, v) != -1 {
-		return []string{v}
+func pageSize() int {
+	value := os.Getenv("KUBENSX_PAGE_SIZE")
+	if value == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Warnf(`Ignored KUBENSX_PAGE_SIZE=%s (expected positive integer)`, value)
+		return 0
+	}
+	return n
+}
+
+func inPlaceMode() (bool, error) {
+	return envBool("KUBENSX_IN_PLACE")
+}
+
+func envBool(key string) (bool, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return false, nil
+	}
+	r, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, nsx.Errorf(nsx.CodeUsage, `%s: expected true or false, got "%s"`, key, value)
 	}
-	return fuzzy.FindFold(v, arr)
+	return r, nil
 }
 
-func newPatternMatcher(cmd *cobra.Command, pattern string) (string, partialMatcher) {
-	if exact, _ := cmd.Flags().GetBool("exact"); exact || strings.HasPrefix(pattern, "=") {
-		return strings.TrimPrefix(pattern, "="), matchExact
+// flagOrEnv returns the flag if it's given explicitly and the environment variable otherwise.
+func flagOrEnv(cmd *cobra.Command, name string, key string) (bool, error) {
+	if cmd.Flags().Changed(name) {
+		return cmd.Flags().GetBool(name)
 	}
-	if tilda, _ := cmd.Flags().GetBool("fuzzy"); tilda || strings.HasPrefix(pattern, "~") {
-		return strings.TrimPrefix(pattern, "~"), matchFuzzy
+	return envBool(key)
+}
+
+// ignoreOptions sets opts.IgnoreAssoc and opts.IgnoreNSList from flags or the environment.
+func ignoreOptions(cmd *cobra.Command, opts *switcher.Options) (err error) {
+	if opts.IgnoreAssoc, err = flagOrEnv(cmd, "ignore-assoc", "KUBENSX_IGNORE_ASSOC"); err != nil {
+		return err
 	}
-	return pattern, matchWildcard
+	opts.IgnoreNSList, err = flagOrEnv(cmd, "ignore-ns-list", "KUBENSX_IGNORE_NS_LIST")
+	return err
 }
 
-func prompt(text string, opts []string, selection string, askUserToSelect bool) string {
+// prompt asks user to select one of the opts. preview is used by external selector (see prompter.Prompter).
+func (a *app) prompt(text string, opts []string, selection string, askUserToSelect bool,
+	preview string) (string, error) {
 	if askUserToSelect && len(opts) > 1 {
-		return promptSelect(text, opts, selection)
+		return a.ui.Select(text, opts, selection, preview)
 	} else {
 		if len(opts) == 1 {
 			selection = opts[0]
 		}
-		return printSelect(text, selection)
+		return prompter.PrintSelected(text, selection), nil
 	}
 }
 
-func printSelect(text string, value string) string {
-	opt := value
-	if opt == "" {
-		opt = `""`
+func (a *app) promptLabeled(text string, opts []string, selection string, askUserToSelect bool,
+	label func(string) string, preview string) (string, error) {
+	labels := make([]string, len(opts))
+	values := make(map[string]string, len(opts))
+	for i, opt := range opts {
+		labels[i] = label(opt)
+		values[labels[i]] = opt
+	}
+	r, err := a.prompt(text, labels, label(selection), askUserToSelect, preview)
+	if err != nil {
+		return "", err
 	}
-	fmt.Println(text + " " + color.CyanString(opt))
+	if value, ok := values[r]; ok {
+		return value, nil
+	}
+	return selection, nil
+}
+
+func noLabel(value string) string {
 	return value
 }
 
-func promptSelect(text string, opts []string, def string) string {
-	value := def
-	if err := survey.AskOne(
-		&survey.Select{
-			Message:            text,
-			Options:            opts,
-			Default:            def,
-			FilterResetDefault: true,
-		},
-		&value,
-		nil,
-	); err != nil {
-		log.Fatal(err)
+func clusterLabel(ctx nsx.Context) func(string) string {
+	tags := ctx.Tags()
+	return func(cluster string) string {
+		if len(tags[cluster]) == 0 {
+			return cluster
+		}
+		return cluster + " " + formatTags(tags[cluster])
 	}
-	return value
 }
 
-func promptMultiSelect(text string, opts []string, def []string) []string {
-	value := def
-	if err := survey.AskOne(
-		&survey.MultiSelect{
-			Message: text,
-			Options: opts,
-			Default: def,
-		},
-		&value,
-		nil,
-	); err != nil {
-		log.Fatal(err)
+// clusterPreview prints server of the cluster under the cursor (see selector.Options.Preview).
+const clusterPreview = `kubectl config view -o jsonpath='{.clusters[?(@.name=="'{1}'")].cluster.server}'`
+
+func contextLabel(contexts map[string]nsx.FQNS) func(string) string {
+	return func(name string) string {
+		return name + " " + color.New(color.Faint).Sprintf("(%s)", formatFQNS(contexts[name]))
 	}
-	return value
 }
 
-func promptInput(text string, def string, help string) string {
-	value := def
-	if err := survey.AskOne(
-		&survey.Input{
-			Message:     text,
-			Default:     def,
-			EditDefault: true,
-			Help:        help,
-		},
-		&value,
-		nil,
-	); err != nil {
-		log.Fatal(err)
+// contextNameOf returns the first named context pointing to fqns.
+func contextNameOf(contexts map[string]nsx.FQNS, fqns nsx.FQNS) string {
+	for _, name := range sortInPlace(keys(contexts)) {
+		if contexts[name] == fqns {
+			return name
+		}
 	}
-	return value
+	return ""
+}
+
+func namespacePreview(user string, cluster string) string {
+	return "kubectl --user " + selector.Quote(user) + " --cluster " + selector.Quote(cluster) +
+		" describe namespace {1}"
 }
 
-func erasePreviousLine() {
-	surveyterminal.CursorPreviousLine(1)
-	surveyterminal.EraseLine(surveyterminal.ERASE_LINE_ALL)
+// expiring are the commands that read or change current context.
+// They switch back first if the deadline set by "kubensx use --for" has passed.
+var expiring = map[string]bool{
+	"kubensx assoc":       true,
+	"kubensx current":     true,
+	"kubensx gc":          true,
+	"kubensx hook":        true,
+	"kubensx ls":          true,
+	"kubensx materialize": true,
+	"kubensx ns-list":     true,
+	"kubensx protect":     true,
+	"kubensx save":        true,
+	"kubensx tag":         true,
+	"kubensx use":         true,
+}
+
+// revertIfExpired switches back once the deadline set by "kubensx use --for" has passed.
+func (a *app) revertIfExpired() {
+	ctx, err := a.openContext(a.context, audit.CommandExpire)
+	if err != nil {
+		log.Debug(err)
+		return
+	}
+	revertTo, deadline, ok := ctx.Expiry()
+	if !ok || time.Now().Before(deadline) {
+		return
+	}
+	ctx.DeleteExpiry()
+	expired := formatContext(ctx)
+	if ctx.User() != revertTo.User || ctx.Cluster() != revertTo.Cluster || ctx.Namespace() != revertTo.NS {
+		ctx.SetCluster(revertTo.Cluster)
+		ctx.SetUser(revertTo.User)
+		ctx.SetNamespace(revertTo.NS)
+		log.Warnf("%s expired at %s. Switched back to %s",
+			expired, deadline.Local().Format("15:04:05"), formatContext(ctx))
+	}
+	if err := ctx.Commit(); err != nil {
+		log.Warn(err)
+	}
+}
+
+func formatProtected(cluster string, namespace string) string {
+	if namespace == "" {
+		return cluster
+	}
+	return cluster + "/" + namespace
+}
+
+// confirmProtected asks user to type the name of the cluster if next context is protected.
+func (a *app) confirmProtected(ctx nsx.Context, prev nsx.FQNS, next nsx.FQNS, yes bool) error {
+	p := a.protection(ctx, prev, next)
+	if p == "" {
+		return nil
+	}
+	fmt.Println(color.New(color.BgRed, color.FgWhite, color.Bold).Sprintf(" PROTECTED %s ", formatFQNS(next)))
+	if yes {
+		return nil
+	}
+	if a.noInput {
+		return nsx.Errorf(nsx.CodeInputRequired,
+			`"%s" is protected (--yes(-y) is required when --no-input is in effect (e.g. stdin is not a terminal))`, p)
+	}
+	cluster, err := a.ui.Input("type cluster name to confirm:", "",
+		"switching to a protected cluster/namespace requires typing the name of the cluster")
+	if err != nil {
+		return err
+	}
+	if cluster != next.Cluster {
+		return nsx.Errorf(nsx.CodeAborted, "Cluster name didn't match. Aborted.")
+	}
+	return nil
+}
+
+func isTerminal(f *os.File) bool {
+	return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
 }
 
 func printWithSelectionHighlighted(arr []string, selection string) {
@@ -985,6 +2824,14 @@ func index(arr []string, val string) int {
 	return -1
 }
 
+func keys(m map[string]nsx.FQNS) []string {
+	r := make([]string, 0, len(m))
+	for key := range m {
+		r = append(r, key)
+	}
+	return r
+}
+
 func sortInPlace(arr []string) []string {
 	sort.Strings(arr)
 	return arr
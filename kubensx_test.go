@@ -0,0 +1,947 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/shyiko/kubensx/audit"
+	"github.com/shyiko/kubensx/backup"
+	nsx "github.com/shyiko/kubensx/context"
+	"github.com/shyiko/kubensx/context/kubectl"
+	"github.com/shyiko/kubensx/pin"
+	"github.com/shyiko/kubensx/prompter"
+	"github.com/shyiko/kubensx/settings"
+	"io/ioutil"
+	k8scorev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8s "k8s.io/client-go/kubernetes"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	k8sclientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestConfig returns kubeconfig with
+// users: alice, bob, minikube (assoc[iated] with minikube cluster),
+// clusters: minikube, prod-eu (#prod, protected), us-east1, us-west1,
+// contexts: gke (alice:us-west1/default, current), eks (bob:us-east1/staging).
+func newTestConfig(t *testing.T) *k8sclientcmdapi.Config {
+	cfg := k8sclientcmdapi.NewConfig()
+	for _, user := range []string{"alice", "bob", "minikube"} {
+		cfg.AuthInfos[user] = k8sclientcmdapi.NewAuthInfo()
+	}
+	for _, cluster := range []string{"minikube", "prod-eu", "us-east1", "us-west1"} {
+		cfg.Clusters[cluster] = k8sclientcmdapi.NewCluster()
+	}
+	cfg.Contexts["gke"] = &k8sclientcmdapi.Context{AuthInfo: "alice", Cluster: "us-west1", Namespace: "default"}
+	cfg.Contexts["eks"] = &k8sclientcmdapi.Context{AuthInfo: "bob", Cluster: "us-east1", Namespace: "staging"}
+	cfg.CurrentContext = "gke"
+	ctx := newTestContext(cfg, kubectl.Options{})
+	ctx.Associate("minikube", "minikube")
+	ctx.Tag("prod-eu", "prod")
+	ctx.Protect("prod-eu", "")
+	if err := ctx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// newTestContext returns context backed by cfg.
+// bob is not allowed to list namespaces in prod-eu.
+func newTestContext(cfg *k8sclientcmdapi.Config, opts kubectl.Options) nsx.Context {
+	nss := map[string][]string{
+		"minikube": {"default", "kube-system"},
+		"prod-eu":  {"default", "app"},
+		"us-east1": {"default", "staging"},
+		"us-west1": {"default", "staging", "dev"},
+	}
+	return kubectl.NewInMemoryContextWithOptions(cfg, func(user string, cluster string) (k8s.Interface, error) {
+		var objects []runtime.Object
+		for _, ns := range nss[cluster] {
+			objects = append(objects, &k8scorev1.Namespace{ObjectMeta: k8smetav1.ObjectMeta{Name: ns}})
+		}
+		client := k8sfake.NewSimpleClientset(objects...)
+		if user == "bob" && cluster == "prod-eu" {
+			client.PrependReactor("list", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, k8serrors.NewForbidden(schema.GroupResource{Resource: "namespaces"}, "",
+					errors.New("rbac"))
+			})
+		}
+		return client, nil
+	}, opts)
+}
+
+func TestMain(m *testing.M) {
+	// tests must not depend on the environment of whoever runs them
+	for key := range kubensxEnv() {
+		os.Unsetenv(key)
+	}
+	flag.Parse()
+	os.Exit(m.Run())
+}
+
+// kubensxEnv returns KUBECONFIG and KUBENSX_* environment variables.
+func kubensxEnv() map[string]string {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "KUBENSX_") || strings.HasPrefix(kv, "KUBECONFIG=") {
+			i := strings.Index(kv, "=")
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	return env
+}
+
+// defaultSettingsPath and defaultAuditPath are settings.Path and audit.Path unless test points them elsewhere.
+var defaultSettingsPath, defaultAuditPath = settings.Path, audit.Path
+
+// run executes kubensx against cfg and returns stdout, stderr and exit code.
+// Config file and settings kubensx exports to the environment are discarded once it's done.
+func run(t *testing.T, cfg *k8sclientcmdapi.Config, args ...string) (string, string, int) {
+	dir, err := ioutil.TempDir("", "kubensx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(path string, env map[string]string) {
+		settings.Path = path
+		for _, s := range settings.All {
+			if value, ok := env[s.Env]; ok {
+				os.Setenv(s.Env, value)
+			} else {
+				os.Unsetenv(s.Env)
+			}
+		}
+	}(settings.Path, kubensxEnv())
+	if settings.Path == defaultSettingsPath {
+		settings.Path = filepath.Join(dir, "config.yaml")
+	}
+	defer func(path string, newCtx func(kubectl.Options) (nsx.Context, error)) {
+		audit.Path, newContext = path, newCtx
+	}(audit.Path, newContext)
+	if audit.Path == defaultAuditPath {
+		audit.Path = filepath.Join(dir, "audit.log")
+	}
+	newContext = func(opts kubectl.Options) (nsx.Context, error) { return newTestContext(cfg, opts), nil }
+	stdout, err := ioutil.TempFile(dir, "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stderr bytes.Buffer
+	defer func(stdout *os.File, stderr *os.File) {
+		os.Stdout, os.Stderr = stdout, stderr
+		log.SetOutput(os.Stderr)
+	}(os.Stdout, os.Stderr)
+	os.Stdout, os.Stderr = stdout, stdout
+	log.SetOutput(&stderr)
+	code := execute(append([]string{"--no-color"}, args...))
+	os.Stdout.Sync()
+	out, err := ioutil.ReadFile(stdout.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out), stderr.String(), code
+}
+
+type testCase struct {
+	args    string
+	code    int
+	stdout  string // "" means stdout is not checked
+	stderr  string // substring ("" means stderr is not checked)
+	state   string // kubensx command used to verify the outcome (e.g. "assoc -l")
+	outcome string // expected output of state command
+}
+
+func (test testCase) run(t *testing.T, setup ...string) {
+	cfg := newTestConfig(t)
+	for _, args := range setup {
+		if _, stderr, code := run(t, cfg, strings.Fields(args)...); code != 0 {
+			t.Fatalf("%s: exited with %d (%s)", args, code, stderr)
+		}
+	}
+	stdout, stderr, code := run(t, cfg, strings.Fields(test.args)...)
+	if code != test.code {
+		t.Fatalf("expected exit code %d, got %d (stdout: %q, stderr: %q)", test.code, code, stdout, stderr)
+	}
+	if test.stdout != "" && stdout != test.stdout {
+		t.Fatalf("expected stdout %q, got %q", test.stdout, stdout)
+	}
+	if !strings.Contains(stderr, test.stderr) {
+		t.Fatalf("expected stderr to contain %q, got %q", test.stderr, stderr)
+	}
+	if test.state != "" {
+		if actual, _, _ := run(t, cfg, strings.Fields(test.state)...); actual != test.outcome {
+			t.Fatalf("%s: expected %q, got %q", test.state, test.outcome, actual)
+		}
+	}
+}
+
+func TestUse(t *testing.T) {
+	for _, test := range []testCase{
+		// <namespace>
+		{args: "use staging", stdout: "Switched to alice:us-west1/staging\n",
+			state: "current", outcome: "alice:us-west1/staging\n"},
+		{args: "use dev", stdout: "Switched to alice:us-west1/dev\n"},
+		{args: "use nope", code: 5, stderr: `"nope" does not match any of the namespaces (expected one of (`},
+		{args: "use e", code: 4, stderr: `"namespace" requires input (--no-input is in effect). Candidates:`},
+		// <cluster>/<namespace>
+		{args: "use east/staging", stdout: "Switched to alice:us-east1/staging\n",
+			state: "current", outcome: "alice:us-east1/staging\n"},
+		{args: "use us/staging", code: 4, stderr: "us-east1\nus-west1\n"},
+		{args: "use nope/default", code: 5,
+			stderr: `"nope" does not match any of the clusters (expected one of (`},
+		{args: "use #prod/app -y", stdout: " PROTECTED alice:prod-eu/app \nSwitched to alice:prod-eu/app\n"},
+		{args: "use #nope/app", code: 5},
+		// <user>:<cluster>(/<namespace>)
+		{args: "use bob:east", stdout: "Switched to bob:us-east1/default\n"},
+		{args: "use bob:east/staging", stdout: "Switched to bob:us-east1/staging\n"},
+		{args: "use nope:east/staging", code: 5, stderr: `"nope" does not match any of the users`},
+		{args: "use .:./.", stdout: "Switched to alice:us-west1/default\n"},
+		// assoc[iations]
+		{args: "use *:mini/default", stdout: "Switched to minikube:minikube/default\n"},
+		{args: "use alice:mini/default", code: 5, stderr: `(expected one of (minikube))`},
+		{args: "use --ignore-assoc alice:mini/default", stdout: "Switched to alice:minikube/default\n"},
+		// namespace validation
+		{args: "use bob:prod/app -y", code: 7, stderr: `"bob" is not allowed to list namespaces in "prod-eu" cluster`},
+		{args: "use bob:prod/app -y -f", stdout: " PROTECTED bob:prod-eu/app \nSwitched to bob:prod-eu/app\n"},
+		// protected
+		{args: "use prod/app", code: 4, stderr: `"prod-eu" is protected`,
+			state: "current", outcome: "alice:us-west1/default\n"},
+		// -u/-c/-n
+		{args: "use -u bob", stdout: "Switched to bob:us-west1/default\n"},
+		{args: "use -c east", stdout: "Switched to alice:us-east1/default\n"},
+		{args: "use -n dev", stdout: "Switched to alice:us-west1/dev\n"},
+		{args: "use -u -c bob", code: 2},
+		// matching modes
+		{args: "use -e us-east1/staging", stdout: "Switched to alice:us-east1/staging\n"},
+		{args: "use -e east/staging", code: 5},
+		{args: "use -z ea1/stg", stdout: "Switched to alice:us-east1/staging\n"},
+		{args: "use -r ^us-e/^s", stdout: "Switched to alice:us-east1/staging\n"},
+		{args: "use /^us-e/", code: 5}, // <namespace>
+		{args: "use -r (/default", code: 2, stderr: `"(" is not a valid regular expression`},
+		{args: "use --case-sensitive East/staging", code: 5},
+		{args: "use EAST/staging", code: 5},
+		// --dry-run
+		{args: "use -x us/staging", stdout: "alice:us-east1/staging\nalice:us-west1/staging\n",
+			state: "current", outcome: "alice:us-west1/default\n"},
+		{args: "use -x alice:*/app", stdout: "alice:prod-eu/app\n"},
+		{args: "use -x *:*/app", code: 7},
+		// --for
+		{args: "use --for -1m east/staging", code: 2},
+		{args: "use --for 15m east/staging", stdout: "Switched to alice:us-east1/staging (for 15m0s)\n"},
+		// interactive (but prompting is not allowed)
+		{args: "use", code: 4, stderr: `"context" requires input (--no-input is in effect)`},
+		{args: "use -c", code: 4, stderr: `"cluster" requires input (--no-input is in effect)`},
+		// named contexts
+		{args: "use @eks", stdout: "Switched to bob:us-east1/staging\n", state: "current", outcome: "bob:us-east1/staging\n"},
+		{args: "use --context eks", stdout: "Switched to bob:us-east1/staging\n"},
+		{args: "use @k", code: 4, stderr: "eks (bob:us-east1/staging)\ngke (alice:us-west1/default)\n"},
+		{args: "use -x @k", stdout: "eks (bob:us-east1/staging)\ngke (alice:us-west1/default)\n"},
+		{args: "use -e @ek", code: 5, stderr: `"ek" does not match any of the contexts (expected one of (eks, gke))`},
+		{args: "use @~gk", stdout: "Switched to alice:us-west1/default\n"},
+		{args: "use -r @^e", stdout: "Switched to bob:us-east1/staging\n"},
+		{args: "use @/(e|g)k/", code: 4},
+		{args: "use --context -c eks", code: 2},
+		{args: "ls --contexts", stdout: "eks\ngke\n"},
+		{args: "ls --contexts -c", code: 2},
+		// unknown flag
+		{args: "use --nope", code: 2},
+	} {
+		t.Run(test.args, func(t *testing.T) {
+			test.run(t)
+		})
+	}
+}
+
+func TestUseInPlace(t *testing.T) {
+	cfg := newTestConfig(t)
+	for _, args := range []string{"use --in-place -n dev", "use --in-place staging"} {
+		if _, stderr, code := run(t, cfg, strings.Fields(args)...); code != 0 {
+			t.Fatalf("%s: exited with %d (%s)", args, code, stderr)
+		}
+	}
+	if cfg.CurrentContext != "gke" || cfg.Contexts["gke"].Namespace != "staging" {
+		t.Fatalf(`expected "gke" to remain current (with namespace changed to staging), got "%s" (%+v)`,
+			cfg.CurrentContext, cfg.Contexts["gke"])
+	}
+	if stdout, _, _ := run(t, cfg, "use", "-"); stdout != "Switched to alice:us-west1/dev\n" {
+		t.Fatalf("unexpected %q", stdout)
+	}
+	// --in-place of the previous run does not carry over
+	if cfg.CurrentContext != "kubensx-current" || cfg.Contexts["gke"].Namespace != "staging" {
+		t.Fatalf(`expected "gke" to be left intact, got "%s" (%+v)`, cfg.CurrentContext, cfg.Contexts["gke"])
+	}
+	// switching cluster cannot be done in place (gke would no longer be what its name says)
+	os.Setenv("KUBENSX_IN_PLACE", "true")
+	defer os.Unsetenv("KUBENSX_IN_PLACE")
+	if stdout, _, _ := run(t, cfg, "use", "east/default"); stdout != "Switched to alice:us-east1/default\n" {
+		t.Fatalf("unexpected %q", stdout)
+	}
+	if cfg.CurrentContext == "gke" || cfg.Contexts["gke"].Cluster != "us-west1" {
+		t.Fatalf(`expected "gke" to be left intact, got "%s" (%+v)`, cfg.CurrentContext, cfg.Contexts["gke"])
+	}
+	os.Setenv("KUBENSX_IN_PLACE", "nope")
+	if _, _, code := run(t, cfg, "use", "dev"); code != 2 {
+		t.Fatalf("expected exit code 2, got %d", code)
+	}
+}
+
+func TestUseFor(t *testing.T) {
+	cfg := newTestConfig(t)
+	for _, args := range []string{"use --for 15m east/staging", "use west/dev"} {
+		if _, stderr, code := run(t, cfg, strings.Fields(args)...); code != 0 {
+			t.Fatalf("%s: exited with %d (%s)", args, code, stderr)
+		}
+	}
+	if revertTo, _, ok := newTestContext(cfg, kubectl.Options{}).Expiry(); ok {
+		t.Fatalf("expected expiry to be dropped by the switch without --for, got %v", revertTo)
+	}
+}
+
+func TestUseForExpired(t *testing.T) {
+	cfg := newTestConfig(t)
+	dir, err := ioutil.TempDir("", "kubensx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(path string) { audit.Path = path }(audit.Path)
+	audit.Path = filepath.Join(dir, "audit.log")
+	if _, stderr, code := run(t, cfg, "use", "--for", "1ns", "east/staging"); code != 0 {
+		t.Fatalf("exited with %d (%s)", code, stderr)
+	}
+	// unrelated commands leave expired context be
+	if _, _, code := run(t, cfg, "config", "view"); code != 0 || cfg.CurrentContext != "kubensx-current" ||
+		cfg.Contexts["kubensx-current"].Cluster != "us-east1" {
+		t.Fatalf("expected context to remain us-east1, got %+v", cfg.Contexts[cfg.CurrentContext])
+	}
+	stdout, stderr, _ := run(t, cfg, "current")
+	if stdout != "alice:us-west1/default\n" || !strings.Contains(stderr, "Switched back to alice:us-west1/default") {
+		t.Fatalf("expected context to be reverted, got %q (%s)", stdout, stderr)
+	}
+	records, err := audit.Read(time.Time{})
+	if err != nil || len(records) != 2 || records[1].Command != audit.CommandExpire {
+		t.Fatalf("expected switch back to be recorded as %q, got %+v (%v)", audit.CommandExpire, records, err)
+	}
+}
+
+func TestUsePrevious(t *testing.T) {
+	for _, test := range []struct {
+		setup []string
+		testCase
+	}{
+		{[]string{"use east/staging"}, testCase{args: "use -", stdout: "Switched to alice:us-west1/default\n"}},
+		{[]string{"use east/staging", "use bob:mini -f --ignore-assoc"},
+			testCase{args: "use -", stdout: "Switched to alice:us-east1/staging\n"}},
+		{nil, testCase{args: "use -", stdout: "Switched to alice:us-west1/default\n"}},
+	} {
+		t.Run(strings.Join(test.setup, ","), func(t *testing.T) {
+			test.run(t, test.setup...)
+		})
+	}
+}
+
+// inPinnedDir makes dir/project/service (with dir/project/.kubensx pinning pattern) the working directory
+// (until the returned func is called).
+func inPinnedDir(t *testing.T, pattern string) (dir string, restore func()) {
+	dir, err := ioutil.TempDir("", "kubensx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := filepath.Join(dir, "project", "service")
+	if err := os.MkdirAll(service, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "project", pin.FileName), []byte(pattern+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(service); err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() {
+		os.Chdir(cwd)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestUseFromDir(t *testing.T) {
+	dir, restore := inPinnedDir(t, "east/staging")
+	defer restore()
+	cfg := newTestConfig(t)
+	if stdout, stderr, code := run(t, cfg, "use", "--from-dir"); stdout != "Switched to alice:us-east1/staging\n" {
+		t.Fatalf("unexpected %q (%d, %s)", stdout, code, stderr)
+	}
+	if _, _, code := run(t, cfg, "use", "--from-dir", "dev"); code != 2 {
+		t.Fatalf("expected exit code 2, got %d", code)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, code := run(t, cfg, "use", "--from-dir"); code != 5 {
+		t.Fatalf("expected exit code 5 (no .kubensx), got %d", code)
+	}
+}
+
+func TestHook(t *testing.T) {
+	cfg := newTestConfig(t)
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		if stdout, _, code := run(t, cfg, "hook", shell); code != 0 || !strings.Contains(stdout, "hook --env "+shell) {
+			t.Fatalf("unexpected %s hook %q (%d)", shell, stdout, code)
+		}
+	}
+	if _, _, code := run(t, cfg, "hook", "tcsh"); code != 2 {
+		t.Fatalf("expected exit code 2, got %d", code)
+	}
+	dir, restore := inPinnedDir(t, "@eks")
+	defer restore()
+	env := map[string]string{"HOME": dir, "KUBECONFIG": filepath.Join(dir, "config"),
+		kubectl.OverlayEnv: "", pinEnv: "", originalKubeconfigEnv: ""}
+	for key, value := range env {
+		defer func(key string, value string, ok bool) {
+			if ok {
+				os.Setenv(key, value)
+			} else {
+				os.Unsetenv(key)
+			}
+		}(key, os.Getenv(key), os.Getenv(key) != "")
+		os.Setenv(key, value)
+	}
+	stdout, stderr, code := run(t, cfg, "hook", "--env", "bash")
+	pinned := filepath.Join(dir, "project", pin.FileName)
+	if code != 0 || !strings.Contains(stdout, "export KUBENSX_PIN='"+pinned+"';\n") ||
+		!strings.Contains(stderr, "Switched to bob:us-east1/staging") {
+		t.Fatalf("expected to enter overlay, got %q (%d, %s)", stdout, code, stderr)
+	}
+	overlay := os.Getenv(kubectl.OverlayEnv)
+	if _, err := os.Stat(overlay); err != nil {
+		t.Fatalf("expected overlay to be created (%v)", err)
+	}
+	// same .kubensx (nothing to do)
+	os.Setenv(pinEnv, pinned)
+	if stdout, _, code := run(t, cfg, "hook", "--env", "bash"); code != 0 || stdout != "" {
+		t.Fatalf("expected no output, got %q (%d)", stdout, code)
+	}
+	if stdout, _, _ := run(t, cfg, "current", "--source"); stdout != pinned+"\n" {
+		t.Fatalf("expected %q, got %q", pinned+"\n", stdout)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(originalKubeconfigEnv, env["KUBECONFIG"])
+	stdout, _, code = run(t, cfg, "hook", "--env", "fish")
+	if expected := "set -gx KUBECONFIG '" + env["KUBECONFIG"] + "';\n" +
+		"set -e KUBENSX_OVERLAY;\nset -e KUBENSX_PIN;\nset -e KUBENSX_ORIGINAL_KUBECONFIG;\n"; stdout != expected {
+		t.Fatalf("expected %q, got %q (%d)", expected, stdout, code)
+	}
+	if _, err := os.Stat(overlay); !os.IsNotExist(err) {
+		t.Fatalf("expected overlay to be deleted (%v)", err)
+	}
+}
+
+func TestInit(t *testing.T) {
+	cfg := newTestConfig(t)
+	for _, test := range []struct {
+		args     string
+		contains []string
+		excludes []string
+	}{
+		{"init bash --prompt", []string{"complete -C", "init --env bash", "PS1='$(kubensx_ps1)'", `bind -x '"\ek": kubensx use'`}, nil},
+		{"init zsh --key \\ew", []string{"compinit", "init --env zsh", `bindkey '\ew' _kubensx_use`}, []string{"PROMPT="}},
+		{"init fish --key=", []string{"complete -c", "init --env fish | source", "function kubensx"}, []string{"bind "}},
+	} {
+		stdout, stderr, code := run(t, cfg, strings.Fields(test.args)...)
+		if code != 0 {
+			t.Fatalf("%s: exited with %d (%s)", test.args, code, stderr)
+		}
+		for _, value := range test.contains {
+			if !strings.Contains(stdout, value) {
+				t.Fatalf("%s: expected %q to be present, got %q", test.args, value, stdout)
+			}
+		}
+		for _, value := range test.excludes {
+			if strings.Contains(stdout, value) {
+				t.Fatalf("%s: expected %q to be absent, got %q", test.args, value, stdout)
+			}
+		}
+	}
+	for _, args := range []string{"init tcsh", "init bash --key '\\ek'", "init --env tcsh"} {
+		if _, _, code := run(t, cfg, strings.Fields(args)...); code != 2 {
+			t.Fatalf("%s: expected exit code 2, got %d", args, code)
+		}
+	}
+}
+
+func TestInitSession(t *testing.T) {
+	dir, restore := inPinnedDir(t, "@eks")
+	defer restore()
+	env := map[string]string{"HOME": dir, "KUBECONFIG": filepath.Join(dir, "config"),
+		kubectl.OverlayEnv: "", pinEnv: "", originalKubeconfigEnv: "", sessionEnv: ""}
+	for key, value := range env {
+		defer func(key string, value string, ok bool) {
+			if ok {
+				os.Setenv(key, value)
+			} else {
+				os.Unsetenv(key)
+			}
+		}(key, os.Getenv(key), os.Getenv(key) != "")
+		os.Setenv(key, value)
+	}
+	cfg := newTestConfig(t)
+	stdout, stderr, code := run(t, cfg, "init", "--env", "zsh")
+	session := os.Getenv(kubectl.OverlayEnv)
+	if code != 0 || session == "" ||
+		!strings.Contains(stdout, "export KUBECONFIG='"+session+string(filepath.ListSeparator)+env["KUBECONFIG"]+"';\n") ||
+		!strings.Contains(stdout, "export KUBENSX_SESSION='"+session+"';\n") {
+		t.Fatalf("expected session to be started, got %q (%d, %s)", stdout, code, stderr)
+	}
+	os.Setenv(sessionEnv, session)
+	os.Setenv(originalKubeconfigEnv, env["KUBECONFIG"])
+	// .kubensx reuses session overlay
+	stdout, stderr, code = run(t, cfg, "hook", "--env", "zsh")
+	pinned := filepath.Join(dir, "project", pin.FileName)
+	if expected := "export KUBENSX_PIN='" + pinned + "';\n"; code != 0 || stdout != expected {
+		t.Fatalf("expected %q, got %q (%d, %s)", expected, stdout, code, stderr)
+	}
+	os.Setenv(pinEnv, pinned)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	// ... which outlives it
+	if stdout, _, code := run(t, cfg, "hook", "--env", "zsh"); code != 0 || stdout != "unset KUBENSX_PIN;\n" {
+		t.Fatalf("expected session to be kept, got %q (%d)", stdout, code)
+	}
+	if _, err := os.Stat(session); err != nil {
+		t.Fatalf("expected session overlay to be kept (%v)", err)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubensx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(path string) { settings.Path = path }(settings.Path)
+	settings.Path = filepath.Join(dir, "config.yaml")
+	for _, key := range []string{"KUBENSX_MATCH", "KUBENSX_PROTECTED"} {
+		defer os.Unsetenv(key)
+		os.Unsetenv(key)
+	}
+	cfg := newTestConfig(t)
+	for _, test := range []struct {
+		args string
+		code int
+	}{
+		{"config set match exact", 0},
+		{"config set protected us-east1/staging", 0},
+		{"config set match nope", 2},
+		{"config set nope exact", 2},
+	} {
+		if _, stderr, code := run(t, cfg, strings.Fields(test.args)...); code != test.code {
+			t.Fatalf("%s: expected exit code %d, got %d (%s)", test.args, test.code, code, stderr)
+		}
+	}
+	if stdout, _, _ := run(t, cfg, "config", "view"); !strings.Contains(stdout, "\nmatch: exact\n") ||
+		!strings.Contains(stdout, "\nprotected: us-east1/staging\n") {
+		t.Fatalf("unexpected %q", stdout)
+	}
+	if _, _, code := run(t, cfg, "use", "east/staging"); code != 5 {
+		t.Fatalf("expected exit code 5 (match: exact), got %d", code)
+	}
+	if _, stderr, code := run(t, cfg, "use", "us-east1/staging"); code != 4 ||
+		!strings.Contains(stderr, `"us-east1/staging" is protected`) {
+		t.Fatalf("expected exit code 4 (protected: us-east1/staging), got %d (%s)", code, stderr)
+	}
+	if stdout, _, _ := run(t, cfg, "use", "-z", "-x", "east/staging"); stdout != "alice:us-east1/staging\n" {
+		t.Fatalf("expected flag to take precedence over the config, got %q", stdout)
+	}
+	os.Setenv("KUBENSX_MATCH", "wildcard")
+	if _, stderr, code := run(t, cfg, "config", "set", "match", "regex"); code != 0 ||
+		!strings.Contains(stderr, "match is overridden by KUBENSX_MATCH") {
+		t.Fatalf("expected a warning, got %d (%s)", code, stderr)
+	}
+	if stdout, _, _ := run(t, cfg, "use", "-x", "east/staging"); stdout != "alice:us-east1/staging\n" {
+		t.Fatalf("expected KUBENSX_MATCH to take precedence over the config, got %q", stdout)
+	}
+	os.Unsetenv("KUBENSX_MATCH")
+	if _, _, code := run(t, cfg, "config", "set", "-d", "match"); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	if data, err := ioutil.ReadFile(settings.Path); err != nil || string(data) != "protected: us-east1/staging\n" {
+		t.Fatalf("unexpected %q (%v)", data, err)
+	}
+}
+
+func TestDrift(t *testing.T) {
+	cfg := newTestConfig(t)
+	if _, stderr, code := run(t, cfg, "use", "@eks"); code != 0 {
+		t.Fatalf("exited with %d (%s)", code, stderr)
+	}
+	// kubectl config use-context gke
+	cfg.CurrentContext = "gke"
+	stdout, _, _ := run(t, cfg, "current", "--verbose")
+	if expected := "alice:us-west1/default\n" +
+		"context: gke\n" +
+		"previous: bob:us-east1/staging\n" +
+		"drift: current-context was changed outside of kubensx " +
+		"(kubensx left kubensx-current (bob:us-east1/staging) current)\n"; stdout != expected {
+		t.Fatalf("expected %q, got %q", expected, stdout)
+	}
+	stdout, stderr, code := run(t, cfg, "use", "-")
+	if code != 0 || stdout != "Switched to bob:us-east1/staging\n" {
+		t.Fatalf("expected to switch back to eks, got %q (%d)", stdout, code)
+	}
+	if expected := "Current context was changed outside of kubensx (kubensx-current (bob:us-east1/staging) -> " +
+		"gke (alice:us-west1/default))"; !strings.Contains(stderr, expected) {
+		t.Fatalf("expected stderr to contain %q, got %q", expected, stderr)
+	}
+	// external change is part of the history
+	if stdout, _, _ := run(t, cfg, "use", "-"); stdout != "Switched to alice:us-west1/default\n" {
+		t.Fatalf("expected to switch back to gke, got %q", stdout)
+	}
+	if stdout, _, _ := run(t, cfg, "current", "-v"); !strings.HasSuffix(stdout, "drift: none\n") {
+		t.Fatalf("expected no drift, got %q", stdout)
+	}
+}
+
+func TestDiffFlag(t *testing.T) {
+	for _, test := range []struct {
+		args     string
+		expected []string // lines expected in the diff
+		state    string
+		outcome  string
+	}{
+		{"use --diff staging", []string{"-current-context: gke", "+current-context: kubensx-current",
+			"+    namespace: staging"},
+			"current", "alice:us-west1/default\n"},
+		{"assoc --diff alice:us-east1", []string{"+ alice:us-east1", "+  name: kubensx-assoc:alice:us-east1"},
+			"assoc -l", "minikube:minikube\n"},
+		{"ns-list --diff bob:prod-eu/app", []string{"+  name: kubensx-ns:bob:prod-eu/app"},
+			"ns-list -l", ""},
+	} {
+		t.Run(test.args, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			stdout, stderr, code := run(t, cfg, strings.Fields(test.args)...)
+			if code != 0 {
+				t.Fatalf("exited with %d (%s)", code, stderr)
+			}
+			for _, line := range append(test.expected, "--- kubeconfig", "+++ kubeconfig") {
+				if !strings.Contains("\n"+stdout, "\n"+line+"\n") {
+					t.Fatalf("expected %q in\n%s", line, stdout)
+				}
+			}
+			if actual, _, _ := run(t, cfg, strings.Fields(test.state)...); actual != test.outcome {
+				t.Fatalf("%s: expected %q, got %q", test.state, test.outcome, actual)
+			}
+		})
+	}
+	if _, _, code := run(t, newTestConfig(t), "use", "--diff", "-x", "staging"); code != 2 {
+		t.Fatalf("expected --diff to be incompatible with --dry-run, got exit code %d", code)
+	}
+}
+
+func TestGC(t *testing.T) {
+	cfg := newTestConfig(t)
+	delete(cfg.Clusters, "minikube")
+	stdout, stderr, code := run(t, cfg, "gc", "--diff")
+	if code != 0 || !strings.Contains(stdout, "\n-  name: kubensx-assoc:minikube:minikube\n") {
+		t.Fatalf("expected assoc[iation] to be shown as deleted, got %q (%d, %s)", stdout, code, stderr)
+	}
+	if cfg.Contexts["kubensx-assoc:minikube:minikube"] == nil {
+		t.Fatal("expected --diff to leave kubeconfig intact")
+	}
+	if _, stderr, code := run(t, cfg, "gc"); code != 0 || cfg.Contexts["kubensx-assoc:minikube:minikube"] != nil {
+		t.Fatalf("expected assoc[iation] to be deleted (%d, %s)", code, stderr)
+	}
+}
+
+func TestUndo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubensx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(dir string) { backup.Dir = dir }(backup.Dir)
+	backup.Dir = filepath.Join(dir, "backups")
+	cfg := newTestConfig(t)
+	if _, _, code := run(t, cfg, "undo"); code != 5 {
+		t.Fatalf("expected exit code 5 (no backups), got %d", code)
+	}
+	kubeconfig := filepath.Join(dir, "config")
+	for _, content := range []string{"current-context: a\n", "current-context: b\n"} {
+		if err := ioutil.WriteFile(kubeconfig, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := backup.Create([]string{kubeconfig}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func() string {
+		data, err := ioutil.ReadFile(kubeconfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	// the latest backup is identical to kubeconfig (the one before is restored)
+	stdout, _, code := run(t, cfg, "undo", "-x")
+	if code != 0 || !strings.Contains(stdout, "\n-current-context: b\n+current-context: a\n") ||
+		read() != "current-context: b\n" {
+		t.Fatalf("expected diff (without changes to kubeconfig), got %q (%d)", stdout, code)
+	}
+	if _, _, code := run(t, cfg, "undo"); code != 4 || read() != "current-context: b\n" {
+		t.Fatalf("expected exit code 4 (confirmation required), got %d", code)
+	}
+	if _, stderr, code := run(t, cfg, "undo", "-y"); code != 0 || read() != "current-context: a\n" {
+		t.Fatalf("expected kubeconfig to be restored, got %q (%d, %s)", read(), code, stderr)
+	}
+	// undo of undo (single-level)
+	if _, stderr, code := run(t, cfg, "undo", "-y"); code != 0 || read() != "current-context: b\n" {
+		t.Fatalf("expected undo to be undone, got %q (%d, %s)", read(), code, stderr)
+	}
+	if _, _, code := run(t, cfg, "undo", "nope"); code != 5 {
+		t.Fatalf("expected exit code 5 (no such backup), got %d", code)
+	}
+	// a, b, a (b was backed up already when the first undo was made)
+	stdout, _, _ = run(t, cfg, "backups", "ls")
+	if n := strings.Count(stdout, kubeconfig); n != 3 {
+		t.Fatalf("expected 3 backups, got %q", stdout)
+	}
+}
+
+func TestAssoc(t *testing.T) {
+	for _, test := range []struct {
+		setup []string
+		testCase
+	}{
+		{nil, testCase{args: "assoc -l", stdout: "minikube:minikube\n"}},
+		{nil, testCase{args: "assoc alice:east", stdout: "+ alice:us-east1\n",
+			state: "assoc -l", outcome: "alice:us-east1\nminikube:minikube\n"}},
+		{nil, testCase{args: "assoc alice:us", stdout: "+ alice:us-east1\n+ alice:us-west1\n"}},
+		{nil, testCase{args: "assoc alice:#prod", stdout: "+ alice:prod-eu\n"}},
+		{nil, testCase{args: "assoc -x alice:us", stdout: "+ alice:us-east1\n+ alice:us-west1\n",
+			state: "assoc -l", outcome: "minikube:minikube\n"}},
+		{nil, testCase{args: "assoc minikube:minikube", stdout: ""}},
+		{nil, testCase{args: "assoc -e alice:us", stdout: ""}},
+		{nil, testCase{args: "assoc -r alice:^us-e", stdout: "+ alice:us-east1\n"}},
+		{nil, testCase{args: "assoc -d minikube:minikube", stdout: "- minikube:minikube\n",
+			state: "assoc -l", outcome: ""}},
+		{[]string{"assoc alice:us"}, testCase{args: "assoc -d alice:*west", stdout: "- alice:us-west1\n",
+			state: "assoc -l", outcome: "alice:us-east1\nminikube:minikube\n"}},
+		{[]string{"assoc alice:us"}, testCase{args: "assoc -d alice", stdout: "- alice:us-east1\n- alice:us-west1\n"}},
+		{[]string{"assoc alice:us"}, testCase{args: "assoc --delete-all",
+			stdout: "- alice:us-east1\n- alice:us-west1\n- minikube:minikube\n", state: "assoc -l", outcome: ""}},
+		{nil, testCase{args: "assoc :east", code: 2, stderr: "<user> cannot be empty"}},
+		{nil, testCase{args: "assoc alice:", code: 2, stderr: "<cluster> cannot be empty"}},
+		{nil, testCase{args: "assoc -d", code: 2}},
+		{nil, testCase{args: "assoc --delete-all alice", code: 2}},
+		{nil, testCase{args: "assoc -l -d alice", code: 2}},
+		{nil, testCase{args: "assoc", code: 4}},
+	} {
+		t.Run(test.args, func(t *testing.T) {
+			test.run(t, test.setup...)
+		})
+	}
+}
+
+func TestNSList(t *testing.T) {
+	for _, test := range []struct {
+		setup []string
+		testCase
+	}{
+		{nil, testCase{args: "ns-list -l", stdout: ""}},
+		{nil, testCase{args: "ns-list bob:prod/app", stdout: "+ bob:prod-eu/app\n",
+			state: "ns-list -l", outcome: "bob:prod-eu/app\n"}},
+		{nil, testCase{args: "ns-list bob:prod/app bob:prod/web",
+			stdout: "+ bob:prod-eu/app\n+ bob:prod-eu/web\n"}},
+		{nil, testCase{args: "ns-list -x bob:prod/app", stdout: "+ bob:prod-eu/app\n",
+			state: "ns-list -l", outcome: ""}},
+		{nil, testCase{args: "ns-list #prod/app", stdout: "+ alice:prod-eu/app\n+ bob:prod-eu/app\n"}},
+		// minikube user is assoc[iated] with minikube cluster only
+		{nil, testCase{args: "ns-list *:*/app", stdout: "+ alice:minikube/app\n+ alice:prod-eu/app\n" +
+			"+ alice:us-east1/app\n+ alice:us-west1/app\n+ bob:minikube/app\n+ bob:prod-eu/app\n" +
+			"+ bob:us-east1/app\n+ bob:us-west1/app\n+ minikube:minikube/app\n"}},
+		{nil, testCase{args: "ns-list --ignore-assoc minikube:prod/app", stdout: "+ minikube:prod-eu/app\n"}},
+		{nil, testCase{args: "ns-list minikube:prod/app", stdout: ""}},
+		{[]string{"ns-list bob:prod/app bob:prod/web"}, testCase{args: "ns-list -d bob:prod/web",
+			stdout: "- bob:prod-eu/web\n", state: "ns-list -l", outcome: "bob:prod-eu/app\n"}},
+		{[]string{"ns-list bob:prod/app alice:east/app"}, testCase{args: "ns-list --delete-all",
+			stdout: "- alice:us-east1/app\n- bob:prod-eu/app\n", state: "ns-list -l", outcome: ""}},
+		// ns-list'ed namespaces are the only ones available for selection
+		{[]string{"ns-list alice:west/staging"}, testCase{args: "use -x west/*", stdout: "alice:us-west1/staging\n"}},
+		{[]string{"ns-list alice:west/staging"}, testCase{args: "use -x --ignore-ns-list west/*",
+			stdout: "alice:us-west1/default\nalice:us-west1/dev\nalice:us-west1/staging\n"}},
+		{[]string{"ns-list bob:prod/app"}, testCase{args: "use -y bob:prod/app", stdout: " PROTECTED bob:prod-eu/app \n" +
+			"Switched to bob:prod-eu/app\n"}},
+		{nil, testCase{args: "ns-list prod", code: 2, stderr: "Expected <user>:<cluster>/<namespace>"}},
+		{nil, testCase{args: "ns-list prod/App", code: 2, stderr: `"App" is not a valid namespace`}},
+		{nil, testCase{args: "ns-list :prod/app", code: 2, stderr: "<user> cannot be empty"}},
+		{nil, testCase{args: "ns-list alice:/app", code: 2, stderr: "<cluster> cannot be empty"}},
+		{nil, testCase{args: "ns-list -d", code: 2}},
+		{nil, testCase{args: "ns-list --delete-all bob:prod/app", code: 2}},
+		{nil, testCase{args: "ns-list", code: 4}},
+	} {
+		t.Run(test.args, func(t *testing.T) {
+			test.run(t, test.setup...)
+		})
+	}
+}
+
+func TestSave(t *testing.T) {
+	for _, test := range []struct {
+		setup []string
+		testCase
+	}{
+		{nil, testCase{args: "save dev", stdout: "+ dev (alice:us-west1/default)\n",
+			state: "ls --contexts", outcome: "dev\neks\ngke\n"}},
+		{nil, testCase{args: "save staging east/staging", stdout: "+ staging (alice:us-east1/staging)\n",
+			state: "save -l", outcome: "staging (alice:us-east1/staging)\n"}},
+		{nil, testCase{args: "save -x staging east/staging", stdout: "+ staging (alice:us-east1/staging)\n",
+			state: "save -l", outcome: ""}},
+		{nil, testCase{args: "save staging us/staging", code: 4, stderr: "alice:us-east1/staging\nalice:us-west1/staging"}},
+		{nil, testCase{args: "save staging nope/staging", code: 5}},
+		{[]string{"save dev"}, testCase{args: "save dev", stdout: ""}},
+		{[]string{"save dev"}, testCase{args: "save dev bob:east/staging", stdout: "+ dev (bob:us-east1/staging)\n"}},
+		{[]string{"save dev"}, testCase{args: "use @dev", stdout: "Switched to alice:us-west1/default\n"}},
+		// contexts not created by kubensx are left intact unless --overwrite is given
+		{nil, testCase{args: "save eks", code: 2, stderr: `"eks" context already exists`}},
+		{nil, testCase{args: "save --overwrite eks", stdout: "+ eks (alice:us-west1/default)\n",
+			state: "save -l", outcome: "eks (alice:us-west1/default)\n"}},
+		{nil, testCase{args: "save kubensx-current", code: 2, stderr: "is not a valid context name"}},
+		{nil, testCase{args: "save", state: "ls --contexts", outcome: "eks\ngke\n"}}, // usage
+		{nil, testCase{args: "save a b c", code: 2}},
+		// cleanup
+		{[]string{"save dev", "save staging east/staging"}, testCase{args: "save -d dev", stdout: "- dev\n",
+			state: "ls --contexts", outcome: "eks\ngke\nstaging\n"}},
+		{[]string{"save dev", "save staging east/staging"}, testCase{args: "save --delete-all",
+			stdout: "- dev\n- staging\n", state: "ls --contexts", outcome: "eks\ngke\n"}},
+		{nil, testCase{args: "save -d eks", code: 5, stderr: `"eks" is not one of the contexts created by kubensx`,
+			state: "ls --contexts", outcome: "eks\ngke\n"}},
+		{nil, testCase{args: "save -d", code: 2}},
+		{nil, testCase{args: "save --delete-all dev", code: 2}},
+	} {
+		t.Run(test.args, func(t *testing.T) {
+			test.run(t, test.setup...)
+		})
+	}
+}
+
+func TestMaterialize(t *testing.T) {
+	for _, test := range []struct {
+		setup []string
+		testCase
+	}{
+		{[]string{"assoc alice:us"}, testCase{args: "materialize --assoc",
+			stdout: "+ alice@us-east1 (alice:us-east1/default)\n+ alice@us-west1 (alice:us-west1/default)\n" +
+				"+ minikube@minikube (minikube:minikube/default)\n",
+			state: "ls --contexts", outcome: "alice@us-east1\nalice@us-west1\neks\ngke\nminikube@minikube\n"}},
+		{nil, testCase{args: "materialize --assoc --template {{.Cluster}}{{range.Tags}}-{{.}}{{end}}",
+			stdout: "+ minikube (minikube:minikube/default)\n"}},
+		{[]string{"assoc bob:#prod"}, testCase{args: "materialize --assoc --template {{range.Tags}}{{.}}{{end}}",
+			code: 2, stderr: `"" is not a valid context name`}},
+		{nil, testCase{args: "materialize --assoc -x", stdout: "+ minikube@minikube (minikube:minikube/default)\n",
+			state: "save -l", outcome: ""}},
+		// namespace of the context materialized before is kept
+		{[]string{"materialize --assoc", "save minikube@minikube minikube:minikube/kube-system"},
+			testCase{args: "materialize --assoc", stdout: "", state: "save -l",
+				outcome: "minikube@minikube (minikube:minikube/kube-system)\n"}},
+		{nil, testCase{args: "materialize --assoc --template eks", stdout: "", stderr: `Skipped "eks"`}},
+		{[]string{"assoc alice:us"}, testCase{args: "materialize --assoc --template {{.User}}", code: 2,
+			stderr: `--template produces "alice" for both alice:us-east1 and alice:us-west1`,
+			state:  "ls --contexts", outcome: "eks\ngke\n"}},
+		// cleanup
+		{[]string{"materialize --assoc"}, testCase{args: "materialize --assoc -d", stdout: "- minikube@minikube\n",
+			state: "ls --contexts", outcome: "eks\ngke\n"}},
+		{[]string{"materialize --assoc"}, testCase{args: "save --delete-all", stdout: "- minikube@minikube\n"}},
+		{nil, testCase{args: "materialize", state: "ls --contexts", outcome: "eks\ngke\n"}}, // usage
+		{nil, testCase{args: "materialize --assoc --template {{", code: 2}},
+	} {
+		t.Run(test.args, func(t *testing.T) {
+			test.run(t, test.setup...)
+		})
+	}
+}
+
+var update = flag.Bool("update", false, "update testdata/*.golden")
+
+// transcript executes kubensx against cfg (answering prompts with answers) and returns
+// the "session" (prompts, answers, stdout, stderr & exit code).
+func transcript(t *testing.T, cfg *k8sclientcmdapi.Config, answers []string, args ...string) string {
+	defer func(newP func(bool) prompter.Prompter) { newPrompter = newP }(newPrompter)
+	newPrompter = func(bool) prompter.Prompter {
+		return &prompter.Script{Answers: answers, Out: os.Stdout}
+	}
+	stdout, stderr, code := run(t, cfg, append([]string{"--no-input=false"}, args...)...)
+	return fmt.Sprintf("$ kubensx %s\n%s%s[exit %d]\n", strings.Join(args, " "), stdout, stderr, code)
+}
+
+func TestInteractive(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		setup   []string
+		args    string
+		answers []string
+		state   string // kubensx command used to verify the outcome
+	}{
+		{name: "use", args: "use", answers: []string{"(other)", "us-east1", "", "staging"}, state: "current"},
+		// nothing is written (no context change, no expiry)
+		{name: "use-dry-run", args: "use -x --for 15m", answers: []string{"(other)", "us-east1", "", "staging"},
+			state: "current -v"},
+		{name: "use-cluster", args: "use -c", answers: []string{"minikube"}, state: "current"},
+		{name: "use-ambiguous", args: "use us/staging", answers: []string{"us-east1"}, state: "current"},
+		{name: "use-protected", args: "use", answers: []string{"(other)", "prod-eu #prod", "", "app", "prod-eu"},
+			state: "current"},
+		{name: "use-protected-mismatch", args: "use prod/app", answers: []string{"prod"}, state: "current"},
+		{name: "use-forbidden", args: "use", answers: []string{"(other)", "prod-eu #prod", "bob", "app", "prod-eu"},
+			state: "current"},
+		{name: "use-no-answer", args: "use", answers: []string{"(other)", "us-east1"}, state: "current"},
+		{name: "use-named", args: "use", answers: []string{"eks (bob:us-east1/staging)"}, state: "current"},
+		{name: "use-context", setup: []string{"use east/staging"}, args: "use --context",
+			answers: []string{""}, state: "current"},
+		{name: "use-context-ambiguous", args: "use @k", answers: []string{"eks (bob:us-east1/staging)"},
+			state: "current"},
+		{name: "assoc", args: "assoc", answers: []string{"alice", "us-east1,us-west1"}, state: "assoc -l"},
+		{name: "assoc-replace", setup: []string{"assoc alice:us"}, args: "assoc", answers: []string{"alice", "prod-eu"},
+			state: "assoc -l"},
+		{name: "ns-list", args: "ns-list", answers: []string{"bob", "prod-eu #prod", "app web"}, state: "ns-list -l"},
+		{name: "ns-list-replace", setup: []string{"ns-list bob:prod/app"}, args: "ns-list",
+			answers: []string{"bob", "prod-eu #prod", "web"}, state: "ns-list -l"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			for _, args := range test.setup {
+				if _, stderr, code := run(t, cfg, strings.Fields(args)...); code != 0 {
+					t.Fatalf("%s: exited with %d (%s)", args, code, stderr)
+				}
+			}
+			actual := transcript(t, cfg, test.answers, strings.Fields(test.args)...) +
+				transcript(t, cfg, nil, strings.Fields(test.state)...)
+			golden := filepath.Join("testdata", test.name+".golden")
+			if *update {
+				if err := ioutil.WriteFile(golden, []byte(actual), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual != string(expected) {
+				t.Fatalf("%s: expected\n%s\ngot\n%s", golden, expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package match
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/renstrom/fuzzysearch/fuzzy"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Span is a [Start, End) range of bytes (within Result.Value) matched by the pattern.
+type Span struct {
+	Start int
+	End   int
+}
+
+type Result struct {
+	Value string
+	Score float64 // 0 (no characters matched) to 1 (exact match)
+	Spans []Span
+}
+
+type Matcher interface {
+	Match(pattern string, values []string) []Result
+}
+
+type MatcherFunc func(pattern string, values []string) []Result
+
+func (f MatcherFunc) Match(pattern string, values []string) []Result {
+	return f(pattern, values)
+}
+
+// Case controls case (in)sensitivity of Exact, Wildcard, Fuzzy and Regex.
+type Case int
+
+const (
+	// SmartCase means case-insensitive unless pattern contains uppercase characters.
+	SmartCase Case = iota
+	CaseSensitive
+	CaseInsensitive
+)
+
+// ParseCase parses "smart", "sensitive" or "insensitive".
+func ParseCase(value string) (Case, error) {
+	switch value {
+	case "smart":
+		return SmartCase, nil
+	case "sensitive":
+		return CaseSensitive, nil
+	case "insensitive":
+		return CaseInsensitive, nil
+	}
+	return SmartCase, fmt.Errorf(`unknown case mode "%s" (expected smart, sensitive or insensitive)`, value)
+}
+
+type caseAwareMatcher struct {
+	match func(pattern string, values []string, fold bool) []Result
+	// literal returns the characters of the pattern that are subject to smart-case check
+	literal func(pattern string) string
+	c       Case
+}
+
+func (m caseAwareMatcher) Match(pattern string, values []string) []Result {
+	var fold bool
+	switch m.c {
+	case SmartCase:
+		fold = !hasUpper(m.literal(pattern))
+	case CaseInsensitive:
+		fold = true
+	}
+	return m.match(pattern, values, fold)
+}
+
+// WithCase returns a copy of m (one of Exact, Wildcard, Fuzzy or Regex) with case mode set to c.
+// Any other matcher is returned as is.
+func WithCase(m Matcher, c Case) Matcher {
+	if cm, ok := m.(caseAwareMatcher); ok {
+		cm.c = c
+		return cm
+	}
+	return m
+}
+
+var (
+	Exact    Matcher = caseAwareMatcher{match: matchExact, literal: identity}
+	Wildcard Matcher = caseAwareMatcher{match: matchWildcard, literal: identity}
+	Fuzzy    Matcher = caseAwareMatcher{match: matchFuzzy, literal: identity}
+	// Regex expects pattern to be a valid regular expression (invalid ones match nothing).
+	Regex Matcher = caseAwareMatcher{match: matchRegex, literal: regexLiteral}
+)
+
+func identity(pattern string) string {
+	return pattern
+}
+
+var regexEscape = regexp.MustCompile(`\\[pP]\{[^}]*\}|\\.`)
+
+// regexLiteral strips escape sequences (e.g. \S, \pL, \p{Greek}) so that they don't disable smart-case.
+func regexLiteral(pattern string) string {
+	return regexEscape.ReplaceAllString(pattern, "")
+}
+
+func hasUpper(value string) bool {
+	for _, c := range value {
+		if unicode.IsUpper(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// All returns values as (zero-scored) results.
+func All(values []string) []Result {
+	r := make([]Result, 0, len(values))
+	for _, value := range values {
+		r = append(r, Result{Value: value})
+	}
+	return r
+}
+
+func Values(results []Result) []string {
+	r := make([]string, 0, len(results))
+	for _, result := range results {
+		r = append(r, result.Value)
+	}
+	return r
+}
+
+// Highlight applies fn to each of the matched ranges of result.Value.
+func Highlight(result Result, fn func(string) string) string {
+	if len(result.Spans) == 0 {
+		return result.Value
+	}
+	var b bytes.Buffer
+	offset := 0
+	for _, span := range normalize(result.Spans) {
+		b.WriteString(result.Value[offset:span.Start])
+		b.WriteString(fn(result.Value[span.Start:span.End]))
+		offset = span.End
+	}
+	b.WriteString(result.Value[offset:])
+	return b.String()
+}
+
+// normalize sorts spans and merges the ones that overlap/touch.
+func normalize(spans []Span) []Span {
+	s := append([]Span(nil), spans...)
+	sort.Slice(s, func(i, j int) bool { return s[i].Start < s[j].Start })
+	r := s[:0]
+	for _, span := range s {
+		if n := len(r); n != 0 && span.Start <= r[n-1].End {
+			if span.End > r[n-1].End {
+				r[n-1].End = span.End
+			}
+			continue
+		}
+		r = append(r, span)
+	}
+	return r
+}
+
+func exact(value string) Result {
+	return Result{Value: value, Score: 1, Spans: []Span{{0, len(value)}}}
+}
+
+func coverage(value string, spans []Span) float64 {
+	if len(value) == 0 {
+		return 1
+	}
+	n := 0
+	for _, span := range normalize(spans) {
+		n += span.End - span.Start
+	}
+	return float64(n) / float64(len(value))
+}
+
+// matchExact returns value identical to the pattern
+// (if there is none and fold is true - all the values equal to the pattern under case-folding).
+func matchExact(pattern string, values []string, fold bool) []Result {
+	var r []Result
+	for _, value := range values {
+		if value == pattern {
+			return []Result{exact(value)}
+		}
+		if fold && strings.EqualFold(value, pattern) {
+			r = append(r, exact(value))
+		}
+	}
+	return r
+}
+
+func matchWildcard(pattern string, values []string, fold bool) []Result {
+	if r := matchExact(pattern, values, fold); len(r) != 0 {
+		return r
+	}
+	var subs []*regexp.Regexp
+	for _, sub := range strings.Split(pattern, "*") {
+		if sub == "" {
+			continue
+		}
+		expr := regexp.QuoteMeta(sub)
+		if fold {
+			expr = "(?i)" + expr
+		}
+		subs = append(subs, regexp.MustCompile(expr))
+	}
+	var r []Result
+nextvalue:
+	for _, value := range values {
+		var spans []Span
+		for _, sub := range subs {
+			loc := sub.FindStringIndex(value)
+			if loc == nil {
+				continue nextvalue
+			}
+			spans = append(spans, Span{loc[0], loc[1]})
+		}
+		r = append(r, Result{Value: value, Score: coverage(value, spans), Spans: spans})
+	}
+	return r
+}
+
+func matchFuzzy(pattern string, values []string, fold bool) []Result {
+	if r := matchExact(pattern, values, fold); len(r) != 0 {
+		return r
+	}
+	find := fuzzy.Find
+	if fold {
+		find = fuzzy.FindFold
+	}
+	var r []Result
+	for _, value := range find(pattern, values) {
+		spans := fuzzySpans(pattern, value, fold)
+		score := coverage(value, spans)
+		if len(spans) > 1 {
+			// prefer contiguous matches
+			score = score / float64(len(spans))
+		}
+		r = append(r, Result{Value: value, Score: score, Spans: spans})
+	}
+	return r
+}
+
+// fuzzySpans greedily locates each of the pattern characters in value.
+func fuzzySpans(pattern string, value string, fold bool) []Span {
+	var spans []Span
+	p := []rune(pattern)
+	i := 0
+	for offset, c := range value {
+		if i == len(p) {
+			break
+		}
+		if c != p[i] && (!fold || unicode.ToLower(c) != unicode.ToLower(p[i])) {
+			continue
+		}
+		i++
+		end := offset + utf8.RuneLen(c)
+		if n := len(spans); n != 0 && spans[n-1].End == offset {
+			spans[n-1].End = end
+		} else {
+			spans = append(spans, Span{offset, end})
+		}
+	}
+	return spans
+}
+
+func matchRegex(pattern string, values []string, fold bool) []Result {
+	if r := matchExact(pattern, values, fold); len(r) != 0 {
+		return r
+	}
+	if fold {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil
+	}
+	var r []Result
+	for _, value := range values {
+		loc := re.FindAllStringIndex(value, -1)
+		if loc == nil {
+			continue
+		}
+		var spans []Span
+		for _, l := range loc {
+			if l[0] != l[1] {
+				spans = append(spans, Span{l[0], l[1]})
+			}
+		}
+		r = append(r, Result{Value: value, Score: coverage(value, spans), Spans: spans})
+	}
+	return r
+}
@@ -0,0 +1,132 @@
+package match
+
+import (
+	"reflect"
+	"testing"
+)
+
+var values = []string{"default", "kube-public", "kube-system", "Staging", "staging-eu", "us-west1", "us-east1"}
+
+func TestMatch(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		matcher  Matcher
+		pattern  string
+		expected []string
+	}{
+		{"exact", Exact, "default", []string{"default"}},
+		{"exact/partial", Exact, "def", nil},
+		{"exact/fold", Exact, "DEFAULT", nil},
+		{"exact/fold/insensitive", WithCase(Exact, CaseInsensitive), "DEFAULT", []string{"default"}},
+		{"exact/smart-case", Exact, "staging", []string{"Staging"}},
+		{"exact/smart-case/upper", Exact, "STAGING", nil},
+		{"wildcard/substring", Wildcard, "kube", []string{"kube-public", "kube-system"}},
+		{"wildcard/star", Wildcard, "us*1", []string{"us-west1", "us-east1"}},
+		{"wildcard/star-only", Wildcard, "*", values},
+		{"wildcard/exact-first", Wildcard, "staging", []string{"Staging"}},
+		{"wildcard/smart-case", Wildcard, "stag", []string{"Staging", "staging-eu"}},
+		{"wildcard/smart-case/upper", Wildcard, "Stag", []string{"Staging"}},
+		{"wildcard/sensitive", WithCase(Wildcard, CaseSensitive), "stag", []string{"staging-eu"}},
+		{"wildcard/meta", Wildcard, "us-w.*", nil},
+		{"fuzzy", Fuzzy, "kbs", []string{"kube-system"}},
+		{"fuzzy/order", Fuzzy, "sbk", nil},
+		{"fuzzy/smart-case", Fuzzy, "usw", []string{"us-west1"}},
+		{"fuzzy/smart-case/upper", Fuzzy, "Sg", []string{"Staging"}},
+		{"regex", Regex, "^us-(east|west)1$", []string{"us-west1", "us-east1"}},
+		{"regex/anchored", Regex, "^kube", []string{"kube-public", "kube-system"}},
+		{"regex/invalid", Regex, "(", nil},
+		{"regex/smart-case/upper", Regex, "^S", []string{"Staging"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			actual := Values(test.matcher.Match(test.pattern, values))
+			if len(actual) == 0 && len(test.expected) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Fatalf("%q: expected %v, got %v", test.pattern, test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestMatchRegexSmartCase(t *testing.T) {
+	// escape sequences (\S) must not disable smart-case
+	if r := Regex.Match(`\Staging`, []string{"STAGING"}); len(r) != 1 {
+		t.Fatalf("expected match, got %v", r)
+	}
+	if r := Regex.Match(`\STAGING`, []string{"staging"}); len(r) != 0 {
+		t.Fatalf("expected no match, got %v", r)
+	}
+}
+
+func TestMatchScore(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		matcher Matcher
+		pattern string
+		better  string
+		worse   string
+	}{
+		{"wildcard/coverage", Wildcard, "stag", "Staging", "staging-eu"},
+		{"fuzzy/contiguous", Fuzzy, "pub", "kube-public", "kube-pxuxb"},
+		{"regex/coverage", Regex, "us-.", "us-west1", "us-west1-long"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := test.matcher.Match(test.pattern, []string{test.worse, test.better})
+			if len(r) != 2 {
+				t.Fatalf("expected both %q and %q to match %q, got %v", test.better, test.worse, test.pattern, Values(r))
+			}
+			score := map[string]float64{r[0].Value: r[0].Score, r[1].Value: r[1].Score}
+			if score[test.better] <= score[test.worse] {
+				t.Fatalf("expected %q to score higher than %q (%v)", test.better, test.worse, score)
+			}
+		})
+	}
+	if r := Exact.Match("default", values); len(r) != 1 || r[0].Score != 1 {
+		t.Fatalf("expected exact match to score 1, got %v", r)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	brackets := func(s string) string { return "[" + s + "]" }
+	for _, test := range []struct {
+		name     string
+		matcher  Matcher
+		pattern  string
+		value    string
+		expected string
+	}{
+		{"exact", Exact, "default", "default", "[default]"},
+		{"wildcard", Wildcard, "us*1", "us-west1", "[us]-west[1]"},
+		{"fuzzy", Fuzzy, "kbs", "kube-system", "[k]u[b]e-[s]ystem"},
+		{"fuzzy/contiguous", Fuzzy, "kub", "kube-system", "[kub]e-system"},
+		{"regex", Regex, "e", "kube-system", "kub[e]-syst[e]m"},
+		{"regex/empty", Regex, "x*", "kube", "kube"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := test.matcher.Match(test.pattern, []string{test.value})
+			if len(r) != 1 {
+				t.Fatalf("expected %q to match %q", test.pattern, test.value)
+			}
+			if actual := Highlight(r[0], brackets); actual != test.expected {
+				t.Fatalf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+	if actual := Highlight(Result{Value: "kube", Spans: []Span{{2, 4}, {0, 1}, {1, 2}}}, brackets); actual != "[kube]" {
+		t.Fatalf("expected overlapping/touching spans to be merged, got %q", actual)
+	}
+}
+
+func TestParseCase(t *testing.T) {
+	for value, expected := range map[string]Case{
+		"smart": SmartCase, "sensitive": CaseSensitive, "insensitive": CaseInsensitive,
+	} {
+		if c, err := ParseCase(value); err != nil || c != expected {
+			t.Fatalf("%q: expected %v, got %v (%v)", value, expected, c, err)
+		}
+	}
+	if _, err := ParseCase("upper"); err == nil {
+		t.Fatal("expected error")
+	}
+}
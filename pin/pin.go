@@ -0,0 +1,51 @@
+// Package pin locates per-directory context pins (.kubensx files).
+//
+// .kubensx contains the same argument "kubensx use" accepts (e.g. alice:us-west1/default, west/dev, @gke).
+package pin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const FileName = ".kubensx"
+
+// Find returns path to .kubensx in dir or the closest of its parents ("" if there is none).
+func Find(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		path := filepath.Join(dir, FileName)
+		fi, err := os.Stat(path)
+		if err == nil && !fi.IsDir() {
+			return path, nil
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// Read returns pattern .kubensx at path pins (the first non-blank line).
+func Read(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line, nil
+		}
+	}
+	return "", fmt.Errorf(`"%s" is empty (expected user:cluster/namespace)`, path)
+}
@@ -0,0 +1,44 @@
+package pin
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubensx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	nested := filepath.Join(dir, "project", "service", "src")
+	if err := os.MkdirAll(nested, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if path, err := Find(nested); err != nil || path != "" {
+		t.Fatalf("expected nothing to be found, got %q (%v)", path, err)
+	}
+	// .kubensx directory is not a pin
+	if err := os.Mkdir(filepath.Join(dir, "project", "service", FileName), 0700); err != nil {
+		t.Fatal(err)
+	}
+	expected := filepath.Join(dir, "project", FileName)
+	if err := ioutil.WriteFile(expected, []byte("\n  alice:us-west1/dev \n@gke\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path, err := Find(nested)
+	if err != nil || path != expected {
+		t.Fatalf("expected %q, got %q (%v)", expected, path, err)
+	}
+	if pattern, err := Read(path); err != nil || pattern != "alice:us-west1/dev" {
+		t.Fatalf("expected alice:us-west1/dev, got %q (%v)", pattern, err)
+	}
+	if err := ioutil.WriteFile(expected, []byte("\n \n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Read(path); err == nil {
+		t.Fatal("expected empty .kubensx to be rejected")
+	}
+}
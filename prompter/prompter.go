@@ -0,0 +1,153 @@
+// Package prompter asks user to select option(s) or enter text.
+// Prompts go to the terminal, to an external selector such as fzf, or replay canned answers (see Script).
+package prompter
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	nsx "github.com/shyiko/kubensx/context"
+	"io"
+	"os"
+	"strings"
+)
+
+type Prompter interface {
+	// Select asks user to select one of the opts.
+	// preview is a shell command external selector uses to preview an option (see selector.Options).
+	Select(text string, opts []string, def string, preview string) (string, error)
+	MultiSelect(text string, opts []string, def []string, preview string) ([]string, error)
+	Input(text string, def string, help string) (string, error)
+	// EraseAnswer removes the last answer from the screen (if applicable).
+	EraseAnswer()
+}
+
+// PrintSelected prints value selected (either by user or automatically) in response to text.
+func PrintSelected(text string, value string) string {
+	fprintSelected(os.Stdout, text, value)
+	return value
+}
+
+func fprintSelected(w io.Writer, text string, value string) {
+	if value == "" {
+		value = `""`
+	}
+	fmt.Fprintln(w, text+" "+color.CyanString(value))
+}
+
+// NoInput is a Prompter that fails with nsx.CodeInputRequired instead of prompting.
+// The error lists the candidates.
+type NoInput struct{}
+
+func (NoInput) Select(text string, opts []string, def string, preview string) (string, error) {
+	return "", inputRequired(text, opts)
+}
+
+func (NoInput) MultiSelect(text string, opts []string, def []string, preview string) ([]string, error) {
+	return nil, inputRequired(text, opts)
+}
+
+func (NoInput) Input(text string, def string, help string) (string, error) {
+	return "", inputRequired(text, nil)
+}
+
+func (NoInput) EraseAnswer() {}
+
+func inputRequired(text string, candidates []string) error {
+	msg := fmt.Sprintf(`"%s" requires input (--no-input is in effect)`, strings.TrimSuffix(text, ":"))
+	if len(candidates) != 0 {
+		msg += ". Candidates:\n" + strings.Join(candidates, "\n")
+	}
+	return nsx.NewError(nsx.CodeInputRequired, errors.New(msg))
+}
+
+// Script is a Prompter that replays canned Answers (one per prompt) and writes transcript of the "conversation" to Out
+// (intended for testing interactive flows).
+// "" stands for the default, multiple options are separated with ",".
+type Script struct {
+	Answers []string
+	Out     io.Writer
+}
+
+func (s *Script) Select(text string, opts []string, def string, preview string) (string, error) {
+	fmt.Fprintf(s.Out, "? %s %s\n", text, formatOptions(opts, []string{def}))
+	answer, err := s.next(text)
+	if err != nil {
+		return "", err
+	}
+	if answer == "" {
+		answer = def
+	}
+	if index(opts, answer) == -1 {
+		return "", fmt.Errorf(`"%s" is not one of the "%s" options`, answer, strings.TrimSuffix(text, ":"))
+	}
+	fprintSelected(s.Out, ">", answer)
+	return answer, nil
+}
+
+func (s *Script) MultiSelect(text string, opts []string, def []string, preview string) ([]string, error) {
+	fmt.Fprintf(s.Out, "? %s %s\n", text, formatOptions(opts, def))
+	answer, err := s.next(text)
+	if err != nil {
+		return nil, err
+	}
+	r := def
+	if answer != "" {
+		r = strings.Split(answer, ",")
+	}
+	for _, value := range r {
+		if index(opts, value) == -1 {
+			return nil, fmt.Errorf(`"%s" is not one of the "%s" options`, value, strings.TrimSuffix(text, ":"))
+		}
+	}
+	fprintSelected(s.Out, ">", strings.Join(r, ", "))
+	return r, nil
+}
+
+func (s *Script) Input(text string, def string, help string) (string, error) {
+	fmt.Fprintf(s.Out, "? %s [%s]\n", text, def)
+	answer, err := s.next(text)
+	if err != nil {
+		return "", err
+	}
+	if answer == "" {
+		answer = def
+	}
+	fprintSelected(s.Out, ">", answer)
+	return answer, nil
+}
+
+func (s *Script) EraseAnswer() {}
+
+func (s *Script) next(text string) (string, error) {
+	if len(s.Answers) == 0 {
+		return "", fmt.Errorf(`no answer for "%s"`, strings.TrimSuffix(text, ":"))
+	}
+	answer := s.Answers[0]
+	s.Answers = s.Answers[1:]
+	return answer, nil
+}
+
+// formatOptions returns opts separated with " | " (def(s) are marked with "*").
+func formatOptions(opts []string, def []string) string {
+	r := make([]string, len(opts))
+	for i, opt := range opts {
+		if opt == "" {
+			opt = `""`
+		}
+		if index(def, opts[i]) != -1 {
+			opt += "*"
+		}
+		r[i] = opt
+	}
+	return strings.Join(r, " | ")
+}
+
+func index(arr []string, value string) int {
+	for i, v := range arr {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,131 @@
+package prompter
+
+import (
+	nsx "github.com/shyiko/kubensx/context"
+	"github.com/shyiko/kubensx/selector"
+	"gopkg.in/AlecAivazis/survey.v1"
+	surveycore "gopkg.in/AlecAivazis/survey.v1/core"
+	surveyterminal "gopkg.in/AlecAivazis/survey.v1/terminal"
+	"strings"
+)
+
+func init() {
+	surveyterminal.DiscardUnsupportedEscapeSequences = true
+	// remove "? " prefix
+	survey.SelectQuestionTemplate = strings.Replace(survey.SelectQuestionTemplate,
+		`{{- color "green+hb"}}{{ QuestionIcon }} {{color "reset"}}`, "", 1)
+	survey.SelectQuestionTemplate = strings.Replace(survey.SelectQuestionTemplate,
+		`{{.Answer}}`, `{{or .Answer "\"\""}}`, 1)
+	survey.SelectQuestionTemplate = strings.Replace(survey.SelectQuestionTemplate,
+		`{{- $choice}}`, `{{- or $choice "\"\""}}`, 1)
+	survey.SelectQuestionTemplate = strings.Replace(survey.SelectQuestionTemplate,
+		`{{- "  "}}{{- color "cyan"}}[Use arrows to move, type to filter{{- if and .Help (not .ShowHelp)}}, {{ HelpInputRune }} for more help{{end}}]{{color "reset"}}`, ``, 1)
+	// remove "? " prefix
+	survey.MultiSelectQuestionTemplate = strings.Replace(survey.MultiSelectQuestionTemplate,
+		`{{- color "green+hb"}}{{ QuestionIcon }} {{color "reset"}}`, "", 1)
+	survey.MultiSelectQuestionTemplate = strings.Replace(survey.MultiSelectQuestionTemplate,
+		`{{- if .ShowAnswer}}`,
+		`{{- if not .ShowAnswer}}{{color "cyan"}} (use space to (multi)select, enter to confirm){{color "reset"}}{{end}}`+
+			`{{- if .ShowAnswer}}`, 1)
+	// "  " -> " " before option
+	survey.MultiSelectQuestionTemplate = strings.Replace(survey.MultiSelectQuestionTemplate,
+		`{{- " "}}{{$option}}`, "{{- $option}}", 1)
+	survey.InputQuestionTemplate = strings.Replace(survey.InputQuestionTemplate,
+		`{{- color "green+hb"}}{{ QuestionIcon }} {{color "reset"}}`, "", 1)
+	survey.InputQuestionTemplate = strings.Replace(survey.InputQuestionTemplate,
+		`[{{ HelpInputRune }} for help]`, "({{ .Help }})", 1)
+	survey.InputQuestionTemplate = strings.Replace(survey.InputQuestionTemplate,
+		`{{.Answer}}`, `{{or .Answer "\"\""}}`, 1)
+	surveycore.MarkedOptionIcon = "+"
+	surveycore.UnmarkedOptionIcon = " "
+}
+
+// Terminal is a Prompter that delegates to KUBENSX_SELECTOR if set and uses survey otherwise.
+type Terminal struct {
+	PageSize int // number of options shown at once (0 means survey's default)
+}
+
+func (t Terminal) Select(text string, opts []string, def string, preview string) (string, error) {
+	if command := selector.Command(); command != "" {
+		r, err := selector.Select(command, opts, []string{def}, selector.Options{Prompt: text, Preview: preview})
+		if err != nil {
+			return "", promptError(err)
+		}
+		return PrintSelected(text, r[0]), nil
+	}
+	value := def
+	if err := survey.AskOne(
+		&survey.Select{
+			Message:            text,
+			Options:            opts,
+			Default:            def,
+			PageSize:           t.PageSize,
+			FilterResetDefault: true,
+		},
+		&value,
+		nil,
+	); err != nil {
+		return "", promptError(err)
+	}
+	return value, nil
+}
+
+func (t Terminal) MultiSelect(text string, opts []string, def []string, preview string) ([]string, error) {
+	if command := selector.Command(); command != "" {
+		r, err := selector.Select(command, opts, def,
+			selector.Options{Prompt: text, Preview: preview, Multi: true})
+		if err != nil {
+			return nil, promptError(err)
+		}
+		PrintSelected(text, strings.Join(r, ", "))
+		return r, nil
+	}
+	value := def
+	if err := survey.AskOne(
+		&survey.MultiSelect{
+			Message:  text,
+			Options:  opts,
+			Default:  def,
+			PageSize: t.PageSize,
+		},
+		&value,
+		nil,
+	); err != nil {
+		return nil, promptError(err)
+	}
+	return value, nil
+}
+
+func (Terminal) Input(text string, def string, help string) (string, error) {
+	value := def
+	if err := survey.AskOne(
+		&survey.Input{
+			Message:     text,
+			Default:     def,
+			EditDefault: true,
+			Help:        help,
+		},
+		&value,
+		nil,
+	); err != nil {
+		return "", promptError(err)
+	}
+	return value, nil
+}
+
+func (Terminal) EraseAnswer() {
+	surveyterminal.CursorPreviousLine(1)
+	surveyterminal.EraseLine(surveyterminal.ERASE_LINE_ALL)
+}
+
+// DisableColor turns off colors in prompts.
+func DisableColor() {
+	surveycore.DisableColor = true
+}
+
+func promptError(err error) error {
+	if err == surveyterminal.InterruptErr || err == selector.ErrAborted {
+		return nsx.NewError(nsx.CodeAborted, err)
+	}
+	return err
+}
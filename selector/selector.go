@@ -0,0 +1,131 @@
+package selector
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// ErrAborted is returned when selection is cancelled (e.g. with Esc/Ctrl-C in fzf).
+var ErrAborted = errors.New("selection aborted")
+
+// Command returns external selector configured through KUBENSX_SELECTOR (e.g. "fzf", "sk --height 40%"),
+// "" if none.
+func Command() string {
+	return strings.TrimSpace(os.Getenv("KUBENSX_SELECTOR"))
+}
+
+type Options struct {
+	Prompt string
+	// Preview is a shell command used to preview option under the cursor.
+	// {1} stands for the first word of the option, as in fzf and skim.
+	Preview string
+	Multi   bool
+}
+
+// Select pipes opts to command, one per line with def first, and returns the option(s) printed back.
+// fzf and skim are given --ansi, --prompt, --preview and --multi.
+// Other commands can read KUBENSX_PROMPT, KUBENSX_PREVIEW and KUBENSX_MULTI from env.
+func Select(command string, opts []string, def []string, o Options) ([]string, error) {
+	if isFinder(command) {
+		command += " --ansi --prompt " + Quote(o.Prompt+" ")
+		if o.Preview != "" {
+			command += " --preview " + Quote(o.Preview)
+		}
+		if o.Multi {
+			command += " --multi"
+		}
+	}
+	var multi string
+	if o.Multi {
+		multi = "1"
+	}
+	cmd := shell(command)
+	cmd.Env = append(os.Environ(),
+		"KUBENSX_PROMPT="+o.Prompt, "KUBENSX_PREVIEW="+o.Preview, "KUBENSX_MULTI="+multi)
+	cmd.Stdin = strings.NewReader(strings.Join(defFirst(opts, def), "\n") + "\n")
+	cmd.Stderr = os.Stderr
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			// fzf exits with 1 (no match) or 130 (interrupted), skim - with 130 (aborted)
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok &&
+				(status.ExitStatus() == 1 || status.ExitStatus() == 130) {
+				return nil, ErrAborted
+			}
+		}
+		return nil, fmt.Errorf(`KUBENSX_SELECTOR "%s" failed: %s`, command, err.Error())
+	}
+	// labels might include ANSI escape sequences (which are not included in the output)
+	byText := make(map[string]string, len(opts))
+	for _, opt := range opts {
+		byText[stripANSI(opt)] = opt
+	}
+	var r []string
+	for _, line := range strings.Split(strings.TrimRight(stdout.String(), "\r\n"), "\n") {
+		if opt, ok := byText[stripANSI(strings.TrimRight(line, "\r"))]; ok {
+			r = append(r, opt)
+		}
+	}
+	if len(r) == 0 {
+		return nil, ErrAborted
+	}
+	if !o.Multi {
+		r = r[:1]
+	}
+	return r, nil
+}
+
+func isFinder(command string) bool {
+	name := strings.Fields(command)[0]
+	name = strings.TrimSuffix(filepath.Base(name), ".exe")
+	return name == "fzf" || name == "fzf-tmux" || name == "sk"
+}
+
+func shell(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
+
+// Quote returns value quoted for sh (or cmd on Windows).
+func Quote(value string) string {
+	if runtime.GOOS == "windows" {
+		return `"` + strings.Replace(value, `"`, `""`, -1) + `"`
+	}
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+func defFirst(opts []string, def []string) []string {
+	r := make([]string, 0, len(opts))
+	isDef := make(map[string]bool, len(def))
+	for _, opt := range def {
+		isDef[opt] = true
+	}
+	for _, opt := range opts {
+		if isDef[opt] {
+			r = append(r, opt)
+		}
+	}
+	for _, opt := range opts {
+		if !isDef[opt] {
+			r = append(r, opt)
+		}
+	}
+	return r
+}
+
+var ansi = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+func stripANSI(value string) string {
+	return ansi.ReplaceAllString(value, "")
+}
@@ -0,0 +1,40 @@
+package selector
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestSelect(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands below require sh")
+	}
+	opts := []string{"a", "b", "c"}
+	for _, test := range []struct {
+		command  string
+		multi    bool
+		expected []string
+		err      error // checked when expected is nil (nil stands for an error other than ErrAborted)
+	}{
+		{command: "head -n 1", expected: []string{"b"}}, // default goes first
+		{command: "tail -n 2", multi: true, expected: []string{"a", "c"}},
+		{command: "tail -n 2", expected: []string{"a"}},
+		{command: "echo nope", err: ErrAborted},
+		{command: "exit 1", err: ErrAborted},   // fzf: no match
+		{command: "exit 130", err: ErrAborted}, // fzf/skim: interrupted
+		{command: "exit 2"},
+		{command: "kubensx-selector-that-does-not-exist"}, // 127
+	} {
+		r, err := Select(test.command, opts, []string{"b"}, Options{Multi: test.multi})
+		if test.expected != nil {
+			if err != nil || !reflect.DeepEqual(r, test.expected) {
+				t.Fatalf("%s: expected %v, got %v (%v)", test.command, test.expected, r, err)
+			}
+			continue
+		}
+		if err == nil || test.err != nil && err != test.err || test.err == nil && err == ErrAborted {
+			t.Fatalf("%s: expected %v, got %v", test.command, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// Package settings loads user configuration (~/.config/kubensx/config.yaml).
+//
+// Each setting is backed by an environment variable (e.g. match - KUBENSX_MATCH) which takes precedence over the
+// value in the file (see Apply).
+package settings
+
+import (
+	"fmt"
+	"github.com/ghodss/yaml"
+	"github.com/shyiko/kubensx/match"
+	"github.com/shyiko/kubensx/switcher"
+	"io/ioutil"
+	"k8s.io/client-go/util/homedir"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Path to the config file (KUBENSX_CONFIG takes precedence over ~/.config/kubensx/config.yaml).
+var Path = func() string {
+	if path := os.Getenv("KUBENSX_CONFIG"); path != "" {
+		return path
+	}
+	return filepath.Join(homedir.HomeDir(), ".config", "kubensx", "config.yaml")
+}()
+
+type Setting struct {
+	Key   string
+	Env   string
+	Usage string
+	// Validate returns error if value is not acceptable (nil means any value is).
+	Validate func(value string) error
+}
+
+// All settings (ordered by key).
+var All = []Setting{
+	{"case", "KUBENSX_CASE", "Case sensitivity of matching (smart|sensitive|insensitive)", func(value string) error {
+		_, err := match.ParseCase(value)
+		return err
+	}},
+	{"color", "KUBENSX_COLOR", "Color output (auto|always|never)", oneOf("auto", "always", "never")},
+	{"history", "KUBENSX_HISTORY", "Number of context switches kept in the audit log (0 - unlimited)", nonNegativeInt},
+	{"ignoreAssoc", "KUBENSX_IGNORE_ASSOC", "Ignore user:cluster assoc[iations] (true|false)", boolean},
+	{"ignoreNSList", "KUBENSX_IGNORE_NS_LIST", "Ignore explicit user:cluster/namespace(s) (true|false)", boolean},
+	{"inPlace", "KUBENSX_IN_PLACE", "Change namespace of the named current context in place (true|false)", boolean},
+	{"kubeconfig", "KUBECONFIG", "Path to the kubeconfig", nil},
+	{"match", "KUBENSX_MATCH", "Matching mode (wildcard|exact|fuzzy|regex)", func(value string) error {
+		_, err := switcher.ParseMode(value)
+		return err
+	}},
+	{"namespaceSelector", "KUBENSX_NAMESPACE_SELECTOR", "Label selector namespaces are listed with (e.g. team=billing)",
+		nil},
+	{"pageSize", "KUBENSX_PAGE_SIZE", "Number of options picker shows at once", positiveInt},
+	{"protected", "KUBENSX_PROTECTED", "Comma-separated <cluster>[/<namespace>]s that require confirmation " +
+		"(on top of kubensx protect)", nil},
+	{"selector", "KUBENSX_SELECTOR", "External selector (e.g. fzf)", nil},
+}
+
+// Lookup returns setting by key.
+func Lookup(key string) (Setting, bool) {
+	for _, s := range All {
+		if s.Key == key {
+			return s, true
+		}
+	}
+	return Setting{}, false
+}
+
+// Load returns key -> value map stored in the config file (empty if file does not exist).
+// Lists (e.g. protected) are joined with ",".
+func Load() (map[string]string, error) {
+	data, err := ioutil.ReadFile(Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]string{}, nil
+		}
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf(`Failed to parse "%s" (%s)`, Path, err.Error())
+	}
+	r := make(map[string]string, len(raw))
+	for key, value := range raw {
+		switch v := value.(type) {
+		case nil:
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			r[key] = strings.Join(items, ",")
+		case float64:
+			r[key] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			r[key] = fmt.Sprint(v)
+		}
+	}
+	return r, nil
+}
+
+// Save writes values to the config file.
+func Save(values map[string]string) error {
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(Path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(Path, data, 0600)
+}
+
+// fromFile holds key -> value of the settings exported by Apply.
+var fromFile = make(map[string]string)
+
+// Apply exports values as environment variables unless they are already set.
+// Unknown keys & invalid values are skipped (and reported).
+// Variables exported by the previous Apply are reverted first (unless changed since).
+func Apply(values map[string]string) []error {
+	for key, value := range fromFile {
+		if s, ok := Lookup(key); ok && os.Getenv(s.Env) == value {
+			os.Unsetenv(s.Env)
+		}
+		delete(fromFile, key)
+	}
+	var errs []error
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s, ok := Lookup(key)
+		if !ok {
+			errs = append(errs, fmt.Errorf(`%s: unknown key "%s"`, Path, key))
+			continue
+		}
+		if os.Getenv(s.Env) != "" {
+			continue
+		}
+		if err := s.Check(values[key]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", Path, err.Error()))
+			continue
+		}
+		os.Setenv(s.Env, values[key])
+		fromFile[key] = values[key]
+	}
+	return errs
+}
+
+// Check returns error if value is not acceptable for the setting.
+func (s Setting) Check(value string) error {
+	if s.Validate == nil {
+		return nil
+	}
+	if err := s.Validate(value); err != nil {
+		return fmt.Errorf("%s: %s", s.Key, err.Error())
+	}
+	return nil
+}
+
+// Value returns effective value of the setting along with its source: "file", "env" or "" if not set.
+func (s Setting) Value() (value string, source string) {
+	value = os.Getenv(s.Env)
+	if value == "" {
+		return "", ""
+	}
+	if v, ok := fromFile[s.Key]; ok && v == value {
+		return value, "file"
+	}
+	return value, "env"
+}
+
+func oneOf(values ...string) func(string) error {
+	return func(value string) error {
+		for _, v := range values {
+			if v == value {
+				return nil
+			}
+		}
+		return fmt.Errorf(`expected one of %s, got "%s"`, strings.Join(values, ", "), value)
+	}
+}
+
+func boolean(value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return fmt.Errorf(`expected true or false, got "%s"`, value)
+	}
+	return nil
+}
+
+func nonNegativeInt(value string) error {
+	if n, err := strconv.Atoi(value); err != nil || n < 0 {
+		return fmt.Errorf(`expected non-negative integer, got "%s"`, value)
+	}
+	return nil
+}
+
+func positiveInt(value string) error {
+	if n, err := strconv.Atoi(value); err != nil || n <= 0 {
+		return fmt.Errorf(`expected positive integer, got "%s"`, value)
+	}
+	return nil
+}
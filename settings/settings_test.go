@@ -0,0 +1,60 @@
+package settings
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubensx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(path string) { Path = path }(Path)
+	Path = filepath.Join(dir, "config.yaml")
+	for _, key := range []string{"KUBENSX_MATCH", "KUBENSX_PAGE_SIZE", "KUBENSX_PROTECTED", "KUBENSX_CASE"} {
+		defer os.Unsetenv(key)
+		os.Unsetenv(key)
+	}
+	if values, err := Load(); err != nil || len(values) != 0 {
+		t.Fatalf("expected no values, got %v (%v)", values, err)
+	}
+	data := "match: fuzzy\npageSize: 20\nprotected: [prod, staging/default]\ncase: nope\nnope: 1\n"
+	if err := ioutil.WriteFile(Path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	values, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("KUBENSX_MATCH", "regex")
+	if errs := Apply(values); len(errs) != 2 {
+		t.Fatalf("expected unknown key & invalid value to be reported, got %v", errs)
+	}
+	for _, test := range []struct {
+		key    string
+		value  string
+		source string
+	}{
+		{"match", "regex", "env"},
+		{"pageSize", "20", "file"},
+		{"protected", "prod,staging/default", "file"},
+		{"case", "", ""},
+	} {
+		s, _ := Lookup(test.key)
+		if value, source := s.Value(); value != test.value || source != test.source {
+			t.Fatalf("%s: expected %q (%s), got %q (%s)", test.key, test.value, test.source, value, source)
+		}
+	}
+	// values exported by the previous Apply are reverted
+	Apply(map[string]string{})
+	if value := os.Getenv("KUBENSX_PAGE_SIZE"); value != "" {
+		t.Fatalf("expected KUBENSX_PAGE_SIZE to be unset, got %q", value)
+	}
+	if value := os.Getenv("KUBENSX_MATCH"); value != "regex" {
+		t.Fatalf("expected KUBENSX_MATCH to be left intact, got %q", value)
+	}
+}
@@ -15,7 +15,7 @@ set -x
 ./kubensx --debug use -x -
 # escaped symbols are expected (vendor/gopkg.in/AlecAivazis/survey.v1/terminal/cursor.go)
 # but not the coloring
-yes | ./kubensx use --debug --no-color
+yes | ./kubensx use --debug --no-color --no-input=false
 
 ./kubensx --debug current
 ./kubensx --debug current -u
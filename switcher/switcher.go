@@ -0,0 +1,514 @@
+// Package switcher resolves <user>:<cluster>/<namespace> patterns against nsx.Context and switches to the result.
+// It never prompts (selecting one of the matches is up to the caller).
+package switcher
+
+import (
+	"fmt"
+	nsx "github.com/shyiko/kubensx/context"
+	"github.com/shyiko/kubensx/match"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type Mode int
+
+const (
+	Wildcard Mode = iota
+	Exact
+	Fuzzy
+	Regex
+)
+
+// ParseMode parses "wildcard", "exact", "fuzzy" or "regex".
+func ParseMode(value string) (Mode, error) {
+	switch value {
+	case "wildcard":
+		return Wildcard, nil
+	case "exact":
+		return Exact, nil
+	case "fuzzy":
+		return Fuzzy, nil
+	case "regex":
+		return Regex, nil
+	}
+	return Wildcard, fmt.Errorf(`unknown matching mode "%s" (expected wildcard, exact, fuzzy or regex)`, value)
+}
+
+// Scope tells which part of the context pattern refers to.
+type Scope int
+
+const (
+	// ScopeAll means pattern is <user>:<cluster>/<namespace>, <user>:<cluster>, <cluster>/<namespace> or <namespace>.
+	ScopeAll Scope = iota
+	ScopeUser
+	ScopeCluster
+	ScopeNamespace
+)
+
+// Ranker scores candidates (the higher the score, the earlier candidate appears among the matches).
+type Ranker interface {
+	Cluster(cluster string) float64
+	User(cluster string, user string) float64
+	Namespace(cluster string, namespace string) float64
+}
+
+type Options struct {
+	// Mode is overridden by pattern prefix ("=" - Exact, "~" - Fuzzy, /.../ - Regex).
+	Mode  Mode
+	Case  match.Case
+	Scope Scope
+	// IgnoreAssoc makes all users available for any cluster regardless of assoc[iations].
+	IgnoreAssoc bool
+	// IgnoreNSList makes namespaces to be listed from the cluster even if ns-list was given.
+	IgnoreNSList bool
+	// Force skips namespace validation (namespace must be given exactly).
+	Force  bool
+	Ranker Ranker // nil means no ranking (matches are ordered by match score)
+	// Protected holds entries protected on top of ctx.Protected(), see Protection.
+	Protected map[string][]string
+}
+
+// Pattern is a pattern split into <user>, <cluster> and <namespace>
+// (segments that were not given are set to the current ones).
+type Pattern struct {
+	User    string
+	Cluster string
+	NS      string
+	// UserExplicit/NSExplicit tell whether <user>/<namespace> was a part of the pattern
+	// (if not, and it doesn't match anything, all candidates are considered to be a match).
+	UserExplicit bool
+	NSExplicit   bool
+}
+
+// separator splits pattern into segments before regular expressions are compiled,
+// which means that ":" and "/" cannot be used inside of an expression.
+var separator = regexp.MustCompile("[:/]")
+
+// Parse splits pattern into segments and returns matcher to be used for each one of them.
+func Parse(current nsx.FQNS, pattern string, opts Options) (Pattern, match.Matcher, error) {
+	pattern, m, err := NewMatcher(pattern, opts.Mode, opts.Case)
+	if err != nil {
+		return Pattern{}, nil, err
+	}
+	p := Pattern{User: current.User, Cluster: current.Cluster, NS: current.NS}
+	switch opts.Scope {
+	case ScopeUser:
+		p.User, p.UserExplicit = pattern, true
+	case ScopeCluster:
+		p.Cluster = pattern
+	case ScopeNamespace:
+		p.NS, p.NSExplicit = pattern, true
+	default:
+		chunks := separator.Split(pattern, 3)
+		switch len(chunks) {
+		case 3: // user:cluster/namespace
+			p = Pattern{User: chunks[0], Cluster: chunks[1], NS: chunks[2], UserExplicit: true, NSExplicit: true}
+		case 2: // user:cluster or cluster/namespace
+			if pattern[len(chunks[0])] == ':' {
+				p.User, p.Cluster, p.UserExplicit = chunks[0], chunks[1], true
+			} else {
+				p.Cluster, p.NS, p.NSExplicit = chunks[0], chunks[1], true
+			}
+		case 1: // namespace
+			p.NS, p.NSExplicit = chunks[0], true
+		}
+	}
+	return p, m, nil
+}
+
+// NewMatcher returns matcher for the given mode along with pattern stripped of the mode prefix.
+// Prefix overrides mode: "=" is Exact, "~" is Fuzzy and /.../ is Regex.
+func NewMatcher(pattern string, mode Mode, c match.Case) (string, match.Matcher, error) {
+	pattern, mode = parseMode(pattern, mode)
+	if mode == Regex {
+		// each of the <user>:<cluster>/<namespace> segments is a separate expression
+		// ("*" and "." are handled by Bind)
+		for _, segment := range separator.Split(pattern, -1) {
+			if segment == "*" || segment == "." {
+				continue
+			}
+			if err := validateRegex(segment); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+	return pattern, newMatcher(mode, c), nil
+}
+
+func parseMode(pattern string, mode Mode) (string, Mode) {
+	switch {
+	case strings.HasPrefix(pattern, "="):
+		return pattern[1:], Exact
+	case strings.HasPrefix(pattern, "~"):
+		return pattern[1:], Fuzzy
+	case len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/"):
+		return pattern[1 : len(pattern)-1], Regex
+	}
+	return pattern, mode
+}
+
+func validateRegex(pattern string) error {
+	if _, err := regexp.Compile(pattern); err != nil {
+		return nsx.Errorf(nsx.CodeUsage, `"%s" is not a valid regular expression (%s)`, pattern,
+			strings.TrimPrefix(err.Error(), "error parsing regexp: "))
+	}
+	return nil
+}
+
+func newMatcher(mode Mode, c match.Case) match.Matcher {
+	var m match.Matcher
+	switch mode {
+	case Exact:
+		m = match.Exact
+	case Fuzzy:
+		m = match.Fuzzy
+	case Regex:
+		m = match.Regex
+	default:
+		m = match.Wildcard
+	}
+	return match.WithCase(m, c)
+}
+
+// Step holds candidates available for selection along with those of them that matched the pattern
+// (ordered by rank, then by match score, then alphabetically).
+type Step struct {
+	Kind       string // "user", "cluster", "namespace" or "context"
+	Pattern    string
+	Candidates []string
+	Matches    []match.Result
+}
+
+// Err returns nsx.CodeNoMatch error if none of the candidates matched the pattern (nil otherwise).
+func (s Step) Err() error {
+	if len(s.Matches) != 0 {
+		return nil
+	}
+	return nsx.Errorf(nsx.CodeNoMatch, `"%s" does not match any of the %ss (expected one of (%s))`,
+		s.Pattern, s.Kind, strings.Join(s.Candidates, ", "))
+}
+
+// Resolver matches pattern one segment at a time (cluster first, then user, then namespace),
+// so that caller can pick one of the matches at each step (see Resolve if all of them are needed).
+type Resolver struct {
+	ctx     nsx.Context
+	opts    Options
+	current nsx.FQNS
+	m       match.Matcher
+	Pattern Pattern
+}
+
+func NewResolver(ctx nsx.Context, pattern string, opts Options) (*Resolver, error) {
+	current := Current(ctx)
+	p, m, err := Parse(current, pattern, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{ctx: ctx, opts: opts, current: current, m: m, Pattern: p}, nil
+}
+
+// Clusters matches cluster segment of the pattern (#<tag> matches clusters tagged with <tag>).
+func (r *Resolver) Clusters() Step {
+	candidates := r.ctx.Clusters()
+	pattern := r.Pattern.Cluster
+	matches := allowingEmpty(pattern, BindCluster(r.ctx, r.m, pattern, r.current.Cluster))(candidates)
+	sortByRank(matches, r.clusterRank())
+	return Step{Kind: "cluster", Pattern: pattern, Candidates: candidates, Matches: matches}
+}
+
+// Users matches user segment of the pattern against the users available for cluster.
+func (r *Resolver) Users(cluster string) Step {
+	candidates := Users(r.ctx, cluster, r.opts.IgnoreAssoc)
+	pattern := r.Pattern.User
+	matches := allowingEmpty(pattern, Bind(r.m, pattern, r.current.User))(candidates)
+	if len(matches) == 0 && !r.Pattern.UserExplicit {
+		matches = match.All(candidates)
+	}
+	sortByRank(matches, r.userRank(cluster))
+	return Step{Kind: "user", Pattern: pattern, Candidates: candidates, Matches: matches}
+}
+
+// Namespaces matches namespace segment of the pattern against the namespaces of user:cluster.
+// nsx.CodeForbidden is returned if user is not allowed to list them, unless Options.Force is set.
+func (r *Resolver) Namespaces(user string, cluster string) (Step, error) {
+	pattern := r.Pattern.NS
+	var candidates []string
+	switch {
+	case pattern == "":
+		// "" is added by allowingEmpty
+	case r.opts.Force:
+		candidates = []string{pattern}
+	default:
+		nss, err := Namespaces(r.ctx, user, cluster, !r.opts.IgnoreNSList)
+		if err != nil {
+			return Step{}, err
+		}
+		if len(nss) == 0 {
+			return Step{}, nsx.Errorf(nsx.CodeForbidden,
+				"It appears that \"%s\" is not allowed to list namespaces in \"%s\" cluster.\n"+
+					"Either use --force(-f) (in which case namespace must be specified --exact|ly) or "+
+					"provide an explicit list of namespaces via `kubensx ns-list`.", user, cluster)
+		}
+		candidates = nss
+	}
+	matches := allowingEmpty(pattern, Bind(r.m, pattern, r.current.NS))(candidates)
+	if len(matches) == 0 && !r.Pattern.NSExplicit {
+		matches = match.All(candidates)
+	}
+	sortByRank(matches, r.namespaceRank(cluster))
+	return Step{Kind: "namespace", Pattern: pattern, Candidates: candidates, Matches: matches}, nil
+}
+
+// Match is one of the contexts pattern resolved to.
+type Match struct {
+	User    match.Result
+	Cluster match.Result
+	NS      match.Result
+}
+
+func (m Match) FQNS() nsx.FQNS {
+	return nsx.FQNS{User: m.User.Value, Cluster: m.Cluster.Value, NS: m.NS.Value}
+}
+
+// Resolve returns all the contexts pattern matches (in order of preference).
+func (r *Resolver) Resolve() ([]Match, error) {
+	var result []Match
+	for _, cluster := range r.Clusters().Matches {
+		for _, user := range r.Users(cluster.Value).Matches {
+			step, err := r.Namespaces(user.Value, cluster.Value)
+			if err != nil {
+				return nil, err
+			}
+			for _, ns := range step.Matches {
+				result = append(result, Match{User: user, Cluster: cluster, NS: ns})
+			}
+		}
+	}
+	return result, nil
+}
+
+// Resolve returns all the contexts pattern matches (in order of preference).
+// nsx.CodeNoMatch error is returned if there are none.
+func Resolve(ctx nsx.Context, pattern string, opts Options) ([]nsx.FQNS, error) {
+	r, err := NewResolver(ctx, pattern, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Clusters().Err(); err != nil {
+		return nil, err
+	}
+	matches, err := r.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, nsx.Errorf(nsx.CodeNoMatch, `"%s" does not match any of the contexts`, pattern)
+	}
+	result := make([]nsx.FQNS, len(matches))
+	for i, m := range matches {
+		result[i] = m.FQNS()
+	}
+	return result, nil
+}
+
+// Contexts matches pattern against the names of the named contexts (see nsx.Context.Contexts)
+// (unlike <user>:<cluster>/<namespace> patterns, context name pattern is not split into segments).
+func Contexts(ctx nsx.Context, pattern string, opts Options) (Step, error) {
+	pattern, mode := parseMode(pattern, opts.Mode)
+	if mode == Regex {
+		if err := validateRegex(pattern); err != nil {
+			return Step{}, err
+		}
+	}
+	contexts := ctx.Contexts()
+	candidates := make([]string, 0, len(contexts))
+	for name := range contexts {
+		candidates = append(candidates, name)
+	}
+	sort.Strings(candidates)
+	var matches []match.Result
+	if pattern == "" || pattern == "*" {
+		matches = match.All(candidates)
+	} else {
+		matches = newMatcher(mode, opts.Case).Match(pattern, candidates)
+	}
+	var rank func(string) float64
+	if opts.Ranker != nil {
+		rank = func(name string) float64 {
+			return opts.Ranker.Namespace(contexts[name].Cluster, contexts[name].NS)
+		}
+	}
+	sortByRank(matches, rank)
+	return Step{Kind: "context", Pattern: pattern, Candidates: candidates, Matches: matches}, nil
+}
+
+// Switch makes fqns current (and commits ctx).
+func Switch(ctx nsx.Context, fqns nsx.FQNS) error {
+	ctx.SetCluster(fqns.Cluster)
+	ctx.SetUser(fqns.User)
+	ctx.SetNamespace(fqns.NS)
+	return ctx.Commit()
+}
+
+func Current(ctx nsx.Context) nsx.FQNS {
+	return nsx.FQNS{User: ctx.User(), Cluster: ctx.Cluster(), NS: ctx.Namespace()}
+}
+
+// Previous returns context that was current before the last switch ("kubensx use -").
+func Previous(ctx nsx.Context) nsx.FQNS {
+	return nsx.FQNS{User: ctx.UserPrevious(), Cluster: ctx.ClusterPrevious(), NS: ctx.NamespacePrevious()}
+}
+
+// Users returns users available for cluster (all of them unless some are assoc[iated] with cluster).
+func Users(ctx nsx.Context, cluster string, ignoreAssoc bool) []string {
+	if !ignoreAssoc {
+		if users := ctx.UsersByCluster()[cluster]; len(users) != 0 {
+			return users
+		}
+	}
+	return ctx.Users()
+}
+
+// Namespaces returns namespaces of user:cluster.
+// If explicit is true, ns-list takes precedence over the namespaces listed from the cluster.
+// Empty slice means that user is not allowed to list namespaces.
+// ctx is left pointing to the same user/cluster/namespace as before the call.
+func Namespaces(ctx nsx.Context, user string, cluster string, explicit bool) ([]string, error) {
+	current := Current(ctx)
+	if current.User != user || current.Cluster != cluster {
+		ctx.Begin()
+		defer ctx.Rollback()
+		ctx.SetCluster(cluster)
+		ctx.SetUser(user)
+	}
+	if explicit {
+		return ctx.NamespaceView()
+	}
+	return ctx.Namespaces()
+}
+
+// Protection returns protected entry (<cluster> or <cluster>/<namespace>) that requires confirmation
+// when switching from one context to another ("" if there is none).
+// Switching within the same protected entry requires no confirmation.
+func Protection(ctx nsx.Context, from nsx.FQNS, to nsx.FQNS, opts Options) string {
+	protected := ctx.Protected()
+	if len(opts.Protected) != 0 {
+		merged := make(map[string][]string, len(protected)+len(opts.Protected))
+		for cluster, nss := range protected {
+			merged[cluster] = append(merged[cluster], nss...)
+		}
+		for cluster, nss := range opts.Protected {
+			merged[cluster] = append(merged[cluster], nss...)
+		}
+		protected = merged
+	}
+	p := protection(protected, to.Cluster, to.NS)
+	if p == "" || to.Cluster == from.Cluster && p == protection(protected, from.Cluster, from.NS) {
+		return ""
+	}
+	return p
+}
+
+func protection(protected map[string][]string, cluster string, namespace string) string {
+	for _, ns := range protected[cluster] {
+		if ns == "" {
+			return cluster
+		}
+	}
+	for _, ns := range protected[cluster] {
+		if ns == namespace {
+			return fmt.Sprintf("%s/%s", cluster, namespace)
+		}
+	}
+	return ""
+}
+
+// Bind binds pattern to m ("." matches def, "*" matches everything).
+func Bind(m match.Matcher, pattern string, def string) func(values []string) []match.Result {
+	switch pattern {
+	case ".":
+		return func(values []string) []match.Result { return []match.Result{{Value: def, Score: 1}} }
+	case "*":
+		return match.All
+	default:
+		return func(values []string) []match.Result { return m.Match(pattern, values) }
+	}
+}
+
+// BindCluster is Bind that also understands #<tag> patterns (which match clusters tagged with <tag>).
+func BindCluster(ctx nsx.Context, m match.Matcher, pattern string, def string) func(values []string) []match.Result {
+	if !strings.HasPrefix(pattern, "#") {
+		return Bind(m, pattern, def)
+	}
+	tagMatcher := Bind(m, strings.TrimPrefix(pattern, "#"), "")
+	tags := ctx.Tags()
+	return func(values []string) []match.Result {
+		var r []match.Result
+		for _, cluster := range values {
+			matches := tagMatcher(tags[cluster])
+			if len(matches) == 0 {
+				continue
+			}
+			// cluster is scored by its best matching tag (no cluster name characters are highlighted)
+			result := match.Result{Value: cluster}
+			for _, m := range matches {
+				if m.Score > result.Score {
+					result.Score = m.Score
+				}
+			}
+			r = append(r, result)
+		}
+		return r
+	}
+}
+
+// allowingEmpty makes "" available for selection when pattern is empty
+// (user, cluster and namespace can be empty per
+// https://kubernetes.io/docs/concepts/configuration/organize-cluster-access-kubeconfig/).
+func allowingEmpty(pattern string, m func(values []string) []match.Result) func(values []string) []match.Result {
+	if pattern != "" {
+		return m
+	}
+	return func(values []string) []match.Result {
+		return m(append([]string{""}, values...))
+	}
+}
+
+func (r *Resolver) clusterRank() func(string) float64 {
+	if r.opts.Ranker == nil {
+		return nil
+	}
+	return r.opts.Ranker.Cluster
+}
+
+func (r *Resolver) userRank(cluster string) func(string) float64 {
+	if r.opts.Ranker == nil {
+		return nil
+	}
+	return func(user string) float64 { return r.opts.Ranker.User(cluster, user) }
+}
+
+func (r *Resolver) namespaceRank(cluster string) func(string) float64 {
+	if r.opts.Ranker == nil {
+		return nil
+	}
+	return func(namespace string) float64 { return r.opts.Ranker.Namespace(cluster, namespace) }
+}
+
+// sortByRank orders matches by rank (highest first), then by how well they matched the pattern,
+// then alphabetically.
+func sortByRank(matches []match.Result, rank func(string) float64) {
+	sort.SliceStable(matches, func(i, j int) bool {
+		if rank != nil {
+			ri, rj := rank(matches[i].Value), rank(matches[j].Value)
+			if ri != rj {
+				return ri > rj
+			}
+		}
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Value < matches[j].Value
+	})
+}
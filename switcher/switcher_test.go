@@ -0,0 +1,369 @@
+package switcher
+
+import (
+	"errors"
+	"fmt"
+	nsx "github.com/shyiko/kubensx/context"
+	"github.com/shyiko/kubensx/context/kubectl"
+	"github.com/shyiko/kubensx/match"
+	k8scorev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8s "k8s.io/client-go/kubernetes"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	k8sclientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"reflect"
+	"testing"
+)
+
+// newTestContext returns context with
+// users: alice, bob, minikube (assoc[iated] with minikube cluster),
+// clusters: minikube, prod-eu (#prod, protected), us-east1, us-west1 (#dev),
+// contexts: gke (alice:us-west1/default, current), arn:aws:eks:us-east-1:1:cluster/main (bob:us-east1/staging).
+// bob is not allowed to list namespaces in prod-eu (but has app namespace ns-list'ed).
+func newTestContext(t *testing.T) nsx.Context {
+	cfg := k8sclientcmdapi.NewConfig()
+	for _, user := range []string{"alice", "bob", "minikube"} {
+		cfg.AuthInfos[user] = k8sclientcmdapi.NewAuthInfo()
+	}
+	for _, cluster := range []string{"minikube", "prod-eu", "us-east1", "us-west1"} {
+		cfg.Clusters[cluster] = k8sclientcmdapi.NewCluster()
+	}
+	cfg.Contexts["gke"] = &k8sclientcmdapi.Context{AuthInfo: "alice", Cluster: "us-west1", Namespace: "default"}
+	cfg.Contexts["arn:aws:eks:us-east-1:1:cluster/main"] = &k8sclientcmdapi.Context{AuthInfo: "bob",
+		Cluster: "us-east1", Namespace: "staging"}
+	cfg.CurrentContext = "gke"
+	nss := map[string][]string{
+		"minikube": {"default", "kube-system"},
+		"prod-eu":  {"default", "app", "kube-system"},
+		"us-east1": {"default", "staging"},
+		"us-west1": {"default", "staging", "dev"},
+	}
+	ctx := kubectl.NewInMemoryContext(cfg, func(user string, cluster string) (k8s.Interface, error) {
+		var objects []runtime.Object
+		for _, ns := range nss[cluster] {
+			objects = append(objects, &k8scorev1.Namespace{ObjectMeta: k8smetav1.ObjectMeta{Name: ns}})
+		}
+		client := k8sfake.NewSimpleClientset(objects...)
+		if user == "bob" && cluster == "prod-eu" {
+			client.PrependReactor("list", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, k8serrors.NewForbidden(schema.GroupResource{Resource: "namespaces"}, "",
+					errors.New("rbac"))
+			})
+		}
+		return client, nil
+	})
+	ctx.Associate("minikube", "minikube")
+	ctx.Tag("prod-eu", "prod")
+	ctx.Tag("us-west1", "dev")
+	ctx.Protect("prod-eu", "")
+	ctx.SetExplicitNamespace("bob", "prod-eu", "app")
+	if err := ctx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return ctx
+}
+
+func TestParse(t *testing.T) {
+	current := nsx.FQNS{User: "alice", Cluster: "us-west1", NS: "default"}
+	for _, test := range []struct {
+		pattern  string
+		scope    Scope
+		expected Pattern
+	}{
+		{"dev", ScopeAll, Pattern{"alice", "us-west1", "dev", false, true}},
+		{"east/", ScopeAll, Pattern{"alice", "east", "", false, true}},
+		{"east/staging", ScopeAll, Pattern{"alice", "east", "staging", false, true}},
+		{"bob:east", ScopeAll, Pattern{"bob", "east", "default", true, false}},
+		{"bob:east/staging", ScopeAll, Pattern{"bob", "east", "staging", true, true}},
+		{":/", ScopeAll, Pattern{"", "", "", true, true}},
+		{"#prod/app", ScopeAll, Pattern{"alice", "#prod", "app", false, true}},
+		{"bob", ScopeUser, Pattern{"bob", "us-west1", "default", true, false}},
+		{"east", ScopeCluster, Pattern{"alice", "east", "default", false, false}},
+		{"dev", ScopeNamespace, Pattern{"alice", "us-west1", "dev", false, true}},
+		{"=east/staging", ScopeAll, Pattern{"alice", "east", "staging", false, true}},
+		{"/^us-.+1$/", ScopeAll, Pattern{"alice", "us-west1", "^us-.+1$", false, true}},
+	} {
+		t.Run(fmt.Sprintf("%s(%v)", test.pattern, test.scope), func(t *testing.T) {
+			actual, _, err := Parse(current, test.pattern, Options{Scope: test.scope})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual != test.expected {
+				t.Fatalf("expected %+v, got %+v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestNewMatcher(t *testing.T) {
+	values := []string{"us-east1", "us-west1", "US-WEST2"}
+	for _, test := range []struct {
+		pattern  string
+		mode     Mode
+		c        match.Case
+		expected []string
+	}{
+		{"west", Wildcard, match.SmartCase, []string{"us-west1", "US-WEST2"}},
+		{"west", Wildcard, match.CaseSensitive, []string{"us-west1"}},
+		{"=west", Wildcard, match.SmartCase, nil},
+		{"=us-west1", Fuzzy, match.SmartCase, []string{"us-west1"}},
+		{"west", Exact, match.SmartCase, nil},
+		{"~usw", Wildcard, match.SmartCase, []string{"us-west1", "US-WEST2"}},
+		{"usw", Fuzzy, match.SmartCase, []string{"us-west1", "US-WEST2"}},
+		{"/1$/", Wildcard, match.SmartCase, []string{"us-east1", "us-west1"}},
+		{"^us-w", Regex, match.SmartCase, []string{"us-west1", "US-WEST2"}},
+		{"//", Wildcard, match.SmartCase, nil},
+	} {
+		t.Run(test.pattern, func(t *testing.T) {
+			pattern, m, err := NewMatcher(test.pattern, test.mode, test.c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual := match.Values(m.Match(pattern, values))
+			if (len(actual) != 0 || len(test.expected) != 0) && !reflect.DeepEqual(actual, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+	for _, pattern := range []string{"/(/", "(:*/."} {
+		if _, _, err := NewMatcher(pattern, Regex, match.SmartCase); nsx.CodeOf(err) != nsx.CodeUsage {
+			t.Fatalf("%s: expected error with code %v, got %v", pattern, nsx.CodeUsage, err)
+		}
+	}
+	if _, _, err := NewMatcher("*:*/.", Regex, match.SmartCase); err != nil {
+		t.Fatalf(`expected "*" and "." to be accepted in regex mode, got %v`, err)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	for _, test := range []struct {
+		pattern  string
+		opts     Options
+		expected []string
+		code     nsx.Code // 0 means no error
+	}{
+		// namespace (within current <user>:<cluster>)
+		{"staging", Options{}, []string{"alice:us-west1/staging"}, 0},
+		{"=staging", Options{}, []string{"alice:us-west1/staging"}, 0},
+		{"e", Options{}, []string{"alice:us-west1/dev", "alice:us-west1/default"}, 0},
+		{"nope", Options{}, nil, nsx.CodeNoMatch},
+		// cluster/namespace
+		{"east/staging", Options{}, []string{"alice:us-east1/staging"}, 0},
+		{"us/staging", Options{}, []string{"alice:us-east1/staging", "alice:us-west1/staging"}, 0},
+		{"*/dev", Options{}, []string{"alice:us-west1/dev"}, 0},
+		{"nope/default", Options{}, nil, nsx.CodeNoMatch},
+		// "." stands for the current one
+		{"./.", Options{}, []string{"alice:us-west1/default"}, 0},
+		{".:east/.", Options{}, []string{"alice:us-east1/default"}, 0},
+		// user:cluster (namespace stays the same (if it doesn't exist - any is a match))
+		{"bob:east", Options{}, []string{"bob:us-east1/default"}, 0},
+		{"bob:prod", Options{}, []string{"bob:prod-eu/app"}, 0},
+		// assoc[iations]: minikube cluster is restricted to minikube user
+		{"*:mini/default", Options{}, []string{"minikube:minikube/default"}, 0},
+		{"bob:mini", Options{}, nil, nsx.CodeNoMatch},
+		{"bob:mini", Options{IgnoreAssoc: true}, []string{"bob:minikube/default"}, 0},
+		{"alice:mini/default", Options{}, nil, nsx.CodeNoMatch},
+		{"alice:mini/default", Options{IgnoreAssoc: true}, []string{"alice:minikube/default"}, 0},
+		// minikube user is not restricted to minikube cluster
+		{"minikube:east/staging", Options{}, []string{"minikube:us-east1/staging"}, 0},
+		// ns-list
+		{"bob:prod/*", Options{}, []string{"bob:prod-eu/app"}, 0},
+		{"bob:prod/*", Options{IgnoreNSList: true}, nil, nsx.CodeForbidden},
+		{"alice:prod/*", Options{}, []string{"alice:prod-eu/app", "alice:prod-eu/default",
+			"alice:prod-eu/kube-system"}, 0},
+		// --force
+		{"bob:prod/whatever", Options{}, nil, nsx.CodeNoMatch},
+		{"bob:prod/whatever", Options{IgnoreNSList: true, Force: true}, []string{"bob:prod-eu/whatever"}, 0},
+		// tags
+		{"#prod/default", Options{}, []string{"alice:prod-eu/default"}, 0},
+		{"#dev/dev", Options{}, []string{"alice:us-west1/dev"}, 0},
+		{"#nope/default", Options{}, nil, nsx.CodeNoMatch},
+		// empty
+		{":/", Options{}, []string{":/"}, 0},
+		{":mini/default", Options{}, []string{":minikube/default"}, 0},
+		{"alice:east/", Options{}, []string{"alice:us-east1/"}, 0},
+		// scope
+		{"bob", Options{Scope: ScopeUser}, []string{"bob:us-west1/default"}, 0},
+		{"east", Options{Scope: ScopeCluster}, []string{"alice:us-east1/default"}, 0},
+		{"stag", Options{Scope: ScopeNamespace}, []string{"alice:us-west1/staging"}, 0},
+		// modes
+		{"ustg", Options{Mode: Fuzzy}, nil, nsx.CodeNoMatch},
+		{"e1/stg", Options{Mode: Fuzzy}, []string{"alice:us-east1/staging", "alice:us-west1/staging"}, 0},
+		{"east/stag", Options{Mode: Exact}, nil, nsx.CodeNoMatch},
+		{"^us-e/^s", Options{Mode: Regex}, []string{"alice:us-east1/staging"}, 0},
+		{"/(/", Options{}, nil, nsx.CodeUsage},
+		// case
+		{"EAST/staging", Options{}, nil, nsx.CodeNoMatch},
+		{"EAST/staging", Options{Case: match.CaseInsensitive}, []string{"alice:us-east1/staging"}, 0},
+	} {
+		t.Run(fmt.Sprintf("%s(%+v)", test.pattern, test.opts), func(t *testing.T) {
+			fqnss, err := Resolve(newTestContext(t), test.pattern, test.opts)
+			if test.code != 0 {
+				if nsx.CodeOf(err) != test.code {
+					t.Fatalf("expected error with code %v, got %v (%v)", test.code, nsx.CodeOf(err), err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actual []string
+			for _, fqns := range fqnss {
+				actual = append(actual, fmt.Sprintf("%s:%s/%s", fqns.User, fqns.Cluster, fqns.NS))
+			}
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func fqns(user string, cluster string, namespace string) nsx.FQNS {
+	return nsx.FQNS{User: user, Cluster: cluster, NS: namespace}
+}
+
+type ranker map[string]float64
+
+func (r ranker) Cluster(cluster string) float64 { return r[cluster] }
+func (r ranker) User(cluster string, user string) float64 {
+	return r[cluster+":"+user]
+}
+func (r ranker) Namespace(cluster string, namespace string) float64 {
+	return r[cluster+"/"+namespace]
+}
+
+func TestResolveRanked(t *testing.T) {
+	ctx := newTestContext(t)
+	rank := ranker{"us-east1": 10, "us-east1:bob": 5, "us-east1/staging": 1}
+	fqnss, err := Resolve(ctx, "*:us/*", Options{Ranker: rank})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []string
+	for _, fqns := range fqnss[:3] {
+		actual = append(actual, fmt.Sprintf("%s:%s/%s", fqns.User, fqns.Cluster, fqns.NS))
+	}
+	expected := []string{"bob:us-east1/staging", "bob:us-east1/default", "alice:us-east1/staging"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestResolverSteps(t *testing.T) {
+	ctx := newTestContext(t)
+	r, err := NewResolver(ctx, "bob:prod/*", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters := r.Clusters()
+	if clusters.Kind != "cluster" || !reflect.DeepEqual(match.Values(clusters.Matches), []string{"prod-eu"}) {
+		t.Fatalf("unexpected %+v", clusters)
+	}
+	users := r.Users("minikube")
+	if err := users.Err(); nsx.CodeOf(err) != nsx.CodeNoMatch {
+		t.Fatalf("expected bob not to be available for minikube cluster, got %+v", users)
+	}
+	if expected := `"bob" does not match any of the users (expected one of (minikube))`; users.Err().Error() != expected {
+		t.Fatalf("expected %s, got %s", expected, users.Err())
+	}
+	nss, err := r.Namespaces("bob", "prod-eu")
+	if err != nil || !reflect.DeepEqual(nss.Candidates, []string{"app"}) {
+		t.Fatalf("unexpected %+v (%v)", nss, err)
+	}
+	// Namespaces must leave ctx as it was
+	if current := Current(ctx); current != (nsx.FQNS{User: "alice", Cluster: "us-west1", NS: "default"}) {
+		t.Fatalf("expected ctx to remain unchanged, got %v", current)
+	}
+	// (including current-context (gke))
+	if before, after, err := ctx.Diff(); err != nil || before != after {
+		t.Fatalf("expected ctx to remain unchanged, got\n%s\n(was\n%s) (%v)", after, before, err)
+	}
+}
+
+func TestContexts(t *testing.T) {
+	ctx := newTestContext(t)
+	eks := "arn:aws:eks:us-east-1:1:cluster/main"
+	for _, test := range []struct {
+		pattern  string
+		mode     Mode
+		expected []string
+		code     nsx.Code // 0 means no error
+	}{
+		{"", Wildcard, []string{eks, "gke"}, 0},
+		{"*", Wildcard, []string{eks, "gke"}, 0},
+		{"g", Wildcard, []string{"gke"}, 0},
+		{"eks:us", Wildcard, []string{eks}, 0}, // context name is not split into segments
+		{"=gke", Wildcard, []string{"gke"}, 0},
+		{"gk", Exact, nil, 0},
+		{"~amn", Wildcard, []string{eks}, 0},
+		{"/^arn:(aws):/", Wildcard, []string{eks}, 0},
+		{"(aws):", Regex, []string{eks}, 0},
+		{"(aws", Regex, nil, nsx.CodeUsage},
+	} {
+		t.Run(test.pattern, func(t *testing.T) {
+			step, err := Contexts(ctx, test.pattern, Options{Mode: test.mode})
+			if nsx.CodeOf(err) != test.code && (test.code != 0 || err != nil) {
+				t.Fatalf("expected error with code %v, got %v", test.code, err)
+			}
+			if actual := match.Values(step.Matches); len(actual) != 0 || len(test.expected) != 0 {
+				if !reflect.DeepEqual(actual, test.expected) {
+					t.Fatalf("expected %v, got %v", test.expected, actual)
+				}
+			}
+		})
+	}
+	step, _ := Contexts(ctx, "", Options{Ranker: ranker{"us-west1/default": 1}})
+	if actual := match.Values(step.Matches); actual[0] != "gke" {
+		t.Fatalf("expected gke to be ranked first, got %v", actual)
+	}
+}
+
+func TestSwitch(t *testing.T) {
+	ctx := newTestContext(t)
+	next := nsx.FQNS{User: "bob", Cluster: "us-east1", NS: "staging"}
+	if err := Switch(ctx, next); err != nil {
+		t.Fatal(err)
+	}
+	if current := Current(ctx); current != next {
+		t.Fatalf("expected %v, got %v", next, current)
+	}
+	if prev := Previous(ctx); prev != (nsx.FQNS{User: "alice", Cluster: "us-west1", NS: "default"}) {
+		t.Fatalf("unexpected previous context %v", prev)
+	}
+}
+
+func TestProtection(t *testing.T) {
+	ctx := newTestContext(t)
+	ctx.Protect("us-east1", "staging")
+	for _, test := range []struct {
+		from     nsx.FQNS
+		to       nsx.FQNS
+		expected string
+	}{
+		{fqns("alice", "us-west1", "default"), fqns("alice", "us-east1", "default"), ""},
+		{fqns("alice", "us-west1", "default"), fqns("alice", "us-east1", "staging"), "us-east1/staging"},
+		{fqns("alice", "us-east1", "default"), fqns("alice", "us-east1", "staging"), "us-east1/staging"},
+		{fqns("alice", "us-east1", "staging"), fqns("bob", "us-east1", "staging"), ""},
+		{fqns("alice", "us-west1", "default"), fqns("alice", "prod-eu", "app"), "prod-eu"},
+		{fqns("alice", "prod-eu", "default"), fqns("alice", "prod-eu", "app"), ""},
+	} {
+		if actual := Protection(ctx, test.from, test.to, Options{}); actual != test.expected {
+			t.Fatalf("%v -> %v: expected %q, got %q", test.from, test.to, test.expected, actual)
+		}
+	}
+}
+
+func TestProtectionExtra(t *testing.T) {
+	ctx := newTestContext(t)
+	opts := Options{Protected: map[string][]string{"us-west1": {"dev"}}}
+	if actual := Protection(ctx, fqns("alice", "us-west1", "default"), fqns("alice", "us-west1", "dev"), opts); actual != "us-west1/dev" {
+		t.Fatalf("expected %q, got %q", "us-west1/dev", actual)
+	}
+	if actual := Protection(ctx, fqns("alice", "us-west1", "default"), fqns("alice", "prod-eu", "app"), opts); actual != "prod-eu" {
+		t.Fatalf("expected ctx.Protected() to be honored, got %q", actual)
+	}
+}